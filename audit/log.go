@@ -8,6 +8,7 @@ import (
 	"io"
 	"log"
 	"os"
+	"path/filepath"
 )
 
 const (
@@ -15,22 +16,40 @@ const (
 	DefaultLogFile = "/run/initramfs/flashback.log"
 )
 
+// LogFile is the path of the log file that is written to
+var LogFile = DefaultLogFile
+
 func logFile() (*os.File, error) {
-	return os.OpenFile(DefaultLogFile, os.O_CREATE|os.O_APPEND|os.O_RDWR, 0644)
+	_ = os.MkdirAll(filepath.Dir(LogFile), os.ModePerm)
+	return os.OpenFile(LogFile, os.O_CREATE|os.O_APPEND|os.O_RDWR, 0644)
+}
+
+// quiet keeps the responses off stdout and out of the log file
+var quiet bool
+
+// Quiet sends the responses to stderr only, for the commands that print a
+// report on stdout and do not change the device
+func Quiet() {
+	quiet = true
+}
+
+// output is where the responses are written
+func output() io.Writer {
+	if quiet {
+		return os.Stderr
+	}
+	l, _ := logFile()
+	return io.MultiWriter(os.Stdout, l)
 }
 
 // Printf records a response
 func Printf(message string, a ...interface{}) {
-	l, _ := logFile()
-	mw := io.MultiWriter(os.Stdout, l)
-	log.SetOutput(mw)
+	log.SetOutput(output())
 	log.Printf(message, a...)
 }
 
 // Println records a response
 func Println(v ...interface{}) {
-	l, _ := logFile()
-	mw := io.MultiWriter(os.Stdout, l)
-	log.SetOutput(mw)
+	log.SetOutput(output())
 	log.Println(v...)
 }
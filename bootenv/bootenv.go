@@ -0,0 +1,45 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+// Flashback
+// Copyright 2018 Canonical Ltd.  All rights reserved.
+
+package bootenv
+
+import (
+	"sort"
+)
+
+// Env is the set of variables in a bootloader environment
+type Env interface {
+	Get(name string) string
+	Set(name, value string)
+	Unset(name string)
+	Write() error
+}
+
+// vars holds the variables of an environment
+type vars map[string]string
+
+// Get returns the value of a variable, or an empty string if it is not set
+func (v vars) Get(name string) string {
+	return v[name]
+}
+
+// Set sets the value of a variable
+func (v vars) Set(name, value string) {
+	v[name] = value
+}
+
+// Unset removes a variable
+func (v vars) Unset(name string) {
+	delete(v, name)
+}
+
+// names returns the variable names in a stable order
+func (v vars) names() []string {
+	names := []string{}
+	for k := range v {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,110 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+// Flashback
+// Copyright 2018 Canonical Ltd.  All rights reserved.
+
+package bootenv
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
+
+const (
+	grubEnvHeader = "# GRUB Environment Block\n"
+	grubEnvSize   = 1024
+
+	// GrubNextEntry is the variable that selects a one-shot boot entry
+	GrubNextEntry = "next_entry"
+)
+
+// GrubEnv is a GRUB environment block e.g. grubenv
+type GrubEnv struct {
+	vars
+	path string
+	size int
+}
+
+// ReadGrub reads a GRUB environment block
+func ReadGrub(path string) (*GrubEnv, error) {
+	dat, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if !bytes.HasPrefix(dat, []byte(grubEnvHeader)) {
+		return nil, fmt.Errorf("invalid GRUB environment block `%s`", path)
+	}
+
+	size := len(dat)
+	if size < grubEnvSize {
+		size = grubEnvSize
+	}
+
+	return &GrubEnv{
+		vars: parseGrubVars(string(dat[len(grubEnvHeader):])),
+		path: path,
+		size: size,
+	}, nil
+}
+
+// Write saves the environment block, padded to its original size
+func (env *GrubEnv) Write() error {
+	data := bytes.NewBufferString(grubEnvHeader)
+	for _, k := range env.names() {
+		data.WriteString(fmt.Sprintf("%s=%s\n", k, escapeGrub(env.vars[k])))
+	}
+	if data.Len() > env.size {
+		return fmt.Errorf("GRUB environment block is too large: %d bytes, %d available", data.Len(), env.size)
+	}
+
+	// The block is padded with comment characters
+	data.WriteString(strings.Repeat("#", env.size-data.Len()))
+
+	return writeSync(env.path, data.Bytes())
+}
+
+// parseGrubVars parses the name=value lines of the environment block. A
+// backslash escapes the next character, including a new line
+func parseGrubVars(data string) vars {
+	v := vars{}
+
+	line := bytes.Buffer{}
+	escaped := false
+	for i := 0; i < len(data); i++ {
+		ch := data[i]
+		switch {
+		case escaped:
+			line.WriteByte(ch)
+			escaped = false
+			continue
+		case ch == '\\':
+			escaped = true
+			continue
+		case ch != '\n':
+			line.WriteByte(ch)
+			continue
+		}
+
+		// End of the line: the padding and comments are skipped
+		entry := line.String()
+		line.Reset()
+		if strings.HasPrefix(entry, "#") {
+			continue
+		}
+		parts := strings.SplitN(entry, "=", 2)
+		if len(parts) != 2 || len(parts[0]) == 0 {
+			continue
+		}
+		v[parts[0]] = parts[1]
+	}
+
+	return v
+}
+
+// escapeGrub escapes the backslashes and new lines in a value
+func escapeGrub(value string) string {
+	value = strings.Replace(value, "\\", "\\\\", -1)
+	return strings.Replace(value, "\n", "\\\n", -1)
+}
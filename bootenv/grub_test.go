@@ -0,0 +1,50 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+// Flashback
+// Copyright 2018 Canonical Ltd.  All rights reserved.
+
+package bootenv_test
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/CanonicalLtd/flashback/bootenv"
+	check "gopkg.in/check.v1"
+)
+
+func (s *bootenvSuite) TestGrub(c *check.C) {
+	path := filepath.Join(s.dir, "grubenv")
+	block := "# GRUB Environment Block\nsaved_entry=0\nflashback_reset=1\n"
+	block += strings.Repeat("#", 1024-len(block))
+	c.Assert(ioutil.WriteFile(path, []byte(block), 0644), check.IsNil)
+
+	env, err := bootenv.ReadGrub(path)
+	c.Assert(err, check.IsNil)
+	c.Assert(env.Get("saved_entry"), check.Equals, "0")
+	c.Assert(env.Get("flashback_reset"), check.Equals, "1")
+
+	env.Unset("flashback_reset")
+	env.Set(bootenv.GrubNextEntry, "Ubuntu Core")
+	env.Set("multi", "line\\one\nline two")
+	c.Assert(env.Write(), check.IsNil)
+
+	dat, err := ioutil.ReadFile(path)
+	c.Assert(err, check.IsNil)
+	c.Assert(len(dat), check.Equals, 1024)
+
+	env, err = bootenv.ReadGrub(path)
+	c.Assert(err, check.IsNil)
+	c.Assert(env.Get("saved_entry"), check.Equals, "0")
+	c.Assert(env.Get("flashback_reset"), check.Equals, "")
+	c.Assert(env.Get(bootenv.GrubNextEntry), check.Equals, "Ubuntu Core")
+	c.Assert(env.Get("multi"), check.Equals, "line\\one\nline two")
+}
+
+func (s *bootenvSuite) TestGrubInvalid(c *check.C) {
+	path := filepath.Join(s.dir, "grubenv")
+	c.Assert(ioutil.WriteFile(path, []byte("saved_entry=0\n"), 0644), check.IsNil)
+
+	_, err := bootenv.ReadGrub(path)
+	c.Assert(err, check.NotNil)
+}
@@ -0,0 +1,175 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+// Flashback
+// Copyright 2018 Canonical Ltd.  All rights reserved.
+
+package bootenv
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+const (
+	ubootCRCSize   = 4
+	ubootFlagsSize = 1
+)
+
+// UBootEnv is a U-Boot environment file e.g. uboot.env. A redundant
+// environment is held in two files, and the newer valid copy is used
+type UBootEnv struct {
+	vars
+	paths  []string
+	size   int
+	active int
+	flags  byte
+}
+
+// ReadUBoot reads a U-Boot environment. The redundant path is only set for
+// a redundant environment
+func ReadUBoot(path, redundant string) (*UBootEnv, error) {
+	env := &UBootEnv{paths: []string{path}}
+	if len(redundant) > 0 {
+		env.paths = append(env.paths, redundant)
+	}
+
+	copies := make([][]byte, len(env.paths))
+	valid := make([]bool, len(env.paths))
+	for i, p := range env.paths {
+		dat, err := ioutil.ReadFile(p)
+		if err != nil {
+			return nil, err
+		}
+		if i == 0 {
+			env.size = len(dat)
+		}
+		if len(dat) != env.size || env.size <= env.headerSize() {
+			return nil, fmt.Errorf("invalid U-Boot environment size for `%s`: %d bytes", p, len(dat))
+		}
+		copies[i] = dat
+		valid[i] = binary.LittleEndian.Uint32(dat) == crc32.ChecksumIEEE(dat[env.headerSize():])
+	}
+
+	env.active = activeCopy(copies, valid)
+	if env.active < 0 {
+		return nil, fmt.Errorf("bad CRC in U-Boot environment `%s`", path)
+	}
+
+	dat := copies[env.active]
+	if len(env.paths) > 1 {
+		env.flags = dat[ubootCRCSize]
+	}
+	env.vars = parseUBootVars(dat[env.headerSize():])
+	return env, nil
+}
+
+// Write saves the environment. For a redundant environment, the older copy
+// is replaced and marked as the newer one
+func (env *UBootEnv) Write() error {
+	dataSize := env.size - env.headerSize()
+
+	data := bytes.Buffer{}
+	for _, k := range env.names() {
+		data.WriteString(fmt.Sprintf("%s=%s", k, env.vars[k]))
+		data.WriteByte(0)
+	}
+	data.WriteByte(0)
+	if data.Len() > dataSize {
+		return fmt.Errorf("U-Boot environment is too large: %d bytes, %d available", data.Len(), dataSize)
+	}
+
+	dat := make([]byte, env.size)
+	copy(dat[env.headerSize():], data.Bytes())
+	binary.LittleEndian.PutUint32(dat, crc32.ChecksumIEEE(dat[env.headerSize():]))
+
+	target := env.active
+	if len(env.paths) > 1 {
+		target = 1 - env.active
+		env.flags++
+		dat[ubootCRCSize] = env.flags
+	}
+
+	if err := writeSync(env.paths[target], dat); err != nil {
+		return err
+	}
+	env.active = target
+	return nil
+}
+
+func (env *UBootEnv) headerSize() int {
+	if len(env.paths) > 1 {
+		return ubootCRCSize + ubootFlagsSize
+	}
+	return ubootCRCSize
+}
+
+// activeCopy selects the copy of the environment to use, using the same
+// rules as U-Boot for an incrementing flag. Returns -1 if no copy is valid
+func activeCopy(copies [][]byte, valid []bool) int {
+	if len(copies) == 1 {
+		if valid[0] {
+			return 0
+		}
+		return -1
+	}
+
+	switch {
+	case valid[0] && !valid[1]:
+		return 0
+	case !valid[0] && valid[1]:
+		return 1
+	case !valid[0] && !valid[1]:
+		return -1
+	}
+
+	flag1 := copies[0][ubootCRCSize]
+	flag2 := copies[1][ubootCRCSize]
+	switch {
+	case flag1 == 255 && flag2 == 0:
+		return 1
+	case flag2 == 255 && flag1 == 0:
+		return 0
+	case flag2 > flag1:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// parseUBootVars parses the NUL-separated name=value pairs of the environment
+func parseUBootVars(data []byte) vars {
+	v := vars{}
+	for _, entry := range bytes.Split(data, []byte{0}) {
+		// The variables end with an empty entry
+		if len(entry) == 0 {
+			break
+		}
+		parts := strings.SplitN(string(entry), "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		v[parts[0]] = parts[1]
+	}
+	return v
+}
+
+// writeSync writes the file in place and flushes it to the disk
+func writeSync(path string, dat []byte) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(dat); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,111 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+// Flashback
+// Copyright 2018 Canonical Ltd.  All rights reserved.
+
+package bootenv_test
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/CanonicalLtd/flashback/bootenv"
+	check "gopkg.in/check.v1"
+)
+
+func TestBootEnv(t *testing.T) { check.TestingT(t) }
+
+type bootenvSuite struct {
+	dir string
+}
+
+var _ = check.Suite(&bootenvSuite{})
+
+func (s *bootenvSuite) SetUpTest(c *check.C) {
+	s.dir = c.MkDir()
+}
+
+// ubootFile creates an environment file in the U-Boot format
+func ubootFile(c *check.C, path string, size int, flags int, data string) {
+	header := 4
+	if flags >= 0 {
+		header = 5
+	}
+	dat := make([]byte, size)
+	copy(dat[header:], data)
+	binary.LittleEndian.PutUint32(dat, crc32.ChecksumIEEE(dat[header:]))
+	if flags >= 0 {
+		dat[4] = byte(flags)
+	}
+	c.Assert(ioutil.WriteFile(path, dat, 0644), check.IsNil)
+}
+
+func (s *bootenvSuite) TestUBoot(c *check.C) {
+	path := filepath.Join(s.dir, "uboot.env")
+	ubootFile(c, path, 4096, -1, "bootcmd=run distro\x00flashback_reset=1\x00\x00")
+
+	env, err := bootenv.ReadUBoot(path, "")
+	c.Assert(err, check.IsNil)
+	c.Assert(env.Get("bootcmd"), check.Equals, "run distro")
+	c.Assert(env.Get("flashback_reset"), check.Equals, "1")
+
+	env.Unset("flashback_reset")
+	env.Set("flashback_status", "success")
+	c.Assert(env.Write(), check.IsNil)
+
+	info, err := os.Stat(path)
+	c.Assert(err, check.IsNil)
+	c.Assert(info.Size(), check.Equals, int64(4096))
+
+	env, err = bootenv.ReadUBoot(path, "")
+	c.Assert(err, check.IsNil)
+	c.Assert(env.Get("bootcmd"), check.Equals, "run distro")
+	c.Assert(env.Get("flashback_reset"), check.Equals, "")
+	c.Assert(env.Get("flashback_status"), check.Equals, "success")
+}
+
+func (s *bootenvSuite) TestUBootBadCRC(c *check.C) {
+	path := filepath.Join(s.dir, "uboot.env")
+	ubootFile(c, path, 1024, -1, "bootcmd=run distro\x00\x00")
+
+	dat, err := ioutil.ReadFile(path)
+	c.Assert(err, check.IsNil)
+	dat[10] = 'X'
+	c.Assert(ioutil.WriteFile(path, dat, 0644), check.IsNil)
+
+	_, err = bootenv.ReadUBoot(path, "")
+	c.Assert(err, check.NotNil)
+}
+
+func (s *bootenvSuite) TestUBootRedundant(c *check.C) {
+	path := filepath.Join(s.dir, "uboot.env")
+	redundant := filepath.Join(s.dir, "uboot-redund.env")
+	ubootFile(c, path, 1024, 4, "copy=old\x00\x00")
+	ubootFile(c, redundant, 1024, 5, "copy=new\x00\x00")
+
+	env, err := bootenv.ReadUBoot(path, redundant)
+	c.Assert(err, check.IsNil)
+	c.Assert(env.Get("copy"), check.Equals, "new")
+
+	// The older copy is replaced and becomes the active one
+	env.Set("copy", "newest")
+	c.Assert(env.Write(), check.IsNil)
+
+	dat, err := ioutil.ReadFile(path)
+	c.Assert(err, check.IsNil)
+	c.Assert(dat[4], check.Equals, byte(6))
+
+	env, err = bootenv.ReadUBoot(path, redundant)
+	c.Assert(err, check.IsNil)
+	c.Assert(env.Get("copy"), check.Equals, "newest")
+
+	// A copy with a bad CRC is ignored
+	dat[10] = 'X'
+	c.Assert(ioutil.WriteFile(path, dat, 0644), check.IsNil)
+	env, err = bootenv.ReadUBoot(path, redundant)
+	c.Assert(err, check.IsNil)
+	c.Assert(env.Get("copy"), check.Equals, "new")
+}
@@ -6,20 +6,23 @@ package bootprint
 
 import (
 	"archive/tar"
-	"compress/gzip"
 	"os"
 	"path/filepath"
+	"time"
 
 	"github.com/CanonicalLtd/flashback/audit"
+	"github.com/CanonicalLtd/flashback/config"
 	"github.com/CanonicalLtd/flashback/core"
+	"github.com/CanonicalLtd/flashback/manifest"
 )
 
-// backupPartition makes a raw backup of a partition
-func backupPartition(devicePath, imagePath string) error {
+// backupPartition makes a raw backup of a partition. Returns the size and
+// checksum of the partition content
+func backupPartition(devicePath, imagePath string) (core.Digest, error) {
 	// Mount the restore path
 	err := core.Mount(core.PartitionTable.Restore, core.RestorePath)
 	if err != nil {
-		return err
+		return core.Digest{}, err
 	}
 
 	// Unmount the boot path
@@ -28,65 +31,137 @@ func backupPartition(devicePath, imagePath string) error {
 	// Back up the partition to img file so we keep the exact filesystem
 	// without having to parse gadget.yaml or worrying about ABI compatibility
 	// to ubuntu-image's dosfstools
-	err = core.ReadAndGzipToFile(devicePath, imagePath)
+	digest, err := core.ReadAndGzipToFile(devicePath, imagePath)
 
 	// Unmount the restore partition
 	_ = core.Unmount(core.RestorePath)
 
-	return err
+	return digest, err
 }
 
 // backupWritable makes a backup of the files on the writable partition
-// We don't use an image as we'd need to regenerate the encryption key
-func backupWritable() error {
+// We don't use an image as we'd need to regenerate the encryption key.
+// Returns the newest modification time of the files in the backup
+func backupWritable() (time.Time, error) {
 	audit.Println("Backup writable partition to the restore partition")
 	// Mount the writable path
 	if err := core.Mount(core.PartitionTable.Writable, core.WritablePath); err != nil {
-		return err
+		return time.Time{}, err
 	}
 
 	// Mount the restore path
 	err := core.Mount(core.PartitionTable.Restore, core.RestorePath)
 	if err != nil {
-		return err
+		return time.Time{}, err
+	}
+
+	// Check the path to system-data
+	source := filepath.Join(core.WritablePath, core.SystemData)
+	if _, err := os.Stat(source); os.IsNotExist(err) {
+		audit.Println("Directory not found:", core.SystemData)
+		return time.Time{}, err
 	}
 
+	// The files are no older than the image they came from
+	newest, err := core.NewestModTime(source)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	// Add the directory to the archive
+	audit.Println("Backup directory:", core.SystemData)
+	if err := writeArchive(core.BackupImageWritable, source); err != nil {
+		return time.Time{}, err
+	}
+
+	// Unmount the writable partition
+	_ = core.Unmount(core.WritablePath)
+	_ = core.Unmount(core.RestorePath)
+
+	return newest, nil
+}
+
+// writeArchive writes a gzipped tar file of a directory and the checksums of
+// its files, and flushes them to the disk
+func writeArchive(path, source string) error {
 	// Create the tar file
-	tarfile, err := os.Create(core.BackupImageWritable)
+	tarfile, err := os.Create(path)
 	if err != nil {
 		return err
 	}
 	defer tarfile.Close()
 
-	// Open the gzip writer
-	gw := gzip.NewWriter(tarfile)
+	// Create the sums file, for the read-back verification of a reset
+	sums, err := os.Create(core.SumsFile(path))
+	if err != nil {
+		return err
+	}
+	defer sums.Close()
+
+	// Open the gzip writer, compressing on all the workers
+	gw := core.NewGzipWriter(tarfile, config.Store.Compression.Workers)
+	// Closing again is harmless, and stops the workers on an error
 	defer gw.Close()
 
 	// Open the tar writer
 	tw := tar.NewWriter(gw)
-	defer tw.Close()
 
-	// Check the path to system-data
-	source := filepath.Join(core.WritablePath, core.SystemData)
-	if _, err := os.Stat(source); os.IsNotExist(err) {
-		audit.Println("Directory not found:", core.SystemData)
+	if err := core.TarSums(source, tw, sums); err != nil {
+		return err
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	if err := gw.Close(); err != nil {
+		return err
+	}
+	if err := sums.Sync(); err != nil {
 		return err
 	}
+	return tarfile.Sync()
+}
 
-	// Add the directory to the archive
-	audit.Println("Backup directory:", core.SystemData)
-	if err := core.Tar(source, tw); err != nil {
+// writeManifest records the details of the recovery image on the restore partition
+func writeManifest(m *manifest.Manifest) error {
+	audit.Println("Record the recovery image details")
+	err := core.Mount(core.PartitionTable.Restore, core.RestorePath)
+	if err != nil {
 		return err
 	}
 
-	// Unmount the writable partition
-	_ = core.Unmount(core.WritablePath)
+	// The deltas of the previous full archive no longer apply
+	removeGenerations()
+
+	err = m.Write(core.ManifestFile)
+
 	_ = core.Unmount(core.RestorePath)
+	return err
+}
 
-	return nil
+// removeGenerations removes the delta archives in the manifest. The restore
+// partition must be mounted
+func removeGenerations() {
+	m, err := manifest.Read(core.ManifestFile)
+	if err != nil {
+		return
+	}
+	for _, g := range m.Generations {
+		audit.Printf("Remove delta generation %d\n", g.Number)
+		removeDelta(g.Number)
+	}
+}
+
+// removeDelta removes the delta archive of a generation and its sums file
+func removeDelta(number int) {
+	archive := core.DeltaArchive(number)
+	for _, path := range []string{archive, core.SumsFile(archive)} {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			audit.Println("Error removing the delta:", err)
+		}
+	}
 }
 
 // backupSystemBoot makes a raw backup of system-boot partition
-func backupSystemBoot() error {
+func backupSystemBoot() (core.Digest, error) {
 	return backupPartition(core.PartitionTable.SystemBoot, core.BackupImageSystemBoot)
 }
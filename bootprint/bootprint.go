@@ -6,39 +6,64 @@ package bootprint
 
 import (
 	"os"
+	"path/filepath"
+	"time"
 
 	"github.com/CanonicalLtd/flashback/audit"
 	"github.com/CanonicalLtd/flashback/core"
+	"github.com/CanonicalLtd/flashback/manifest"
+	"github.com/CanonicalLtd/flashback/metrics"
 )
 
+// Result is what a bootprint did, for the history
+type Result struct {
+	// Created is whether the run created the recovery image, or a generation of it
+	Created bool
+	// Generation is the generation of the recovery image that the run
+	// created. Generation 0 is the full writable archive
+	Generation int
+}
+
 // CheckAndRun verifies that a restore partition has been created
 // If not, it initiates the creation of the restore partition
-func CheckAndRun(check bool) error {
+func CheckAndRun(check bool) (Result, error) {
+	result, err := checkAndRun(check)
+	return result, core.NewError(core.FailureBootprint, err)
+}
+
+func checkAndRun(check bool) (Result, error) {
 	// Find the partition devices
 	err := core.FindPartitions()
 	if err != nil {
-		return err
+		return Result{}, err
 	}
 
 	// Mount the restore path
-	if err = core.Mount(core.PartitionTable.Restore, core.RestorePath); err != nil {
-		return err
+	if err = core.MountReadOnly(core.PartitionTable.Restore, core.RestorePath); err != nil {
+		return Result{}, core.NewError(core.FailureBootprint, err)
 	}
 
 	if check {
 		// Check that the backup files exist
-		backupBoot := false
+		backupBoot := !core.SystemBootImaged()
 		backupWritable := false
 		if _, err := os.Stat(core.BackupImageSystemBoot); err == nil {
 			backupBoot = true
 		}
+		if len(core.PartitionTable.Slots) > 0 {
+			backupBoot = slotsBackedUp()
+		}
 		if _, err := os.Stat(core.BackupImageWritable); err == nil {
 			backupWritable = true
 		}
 		if backupBoot && backupWritable {
 			audit.Println("Recovery image is already created")
+			if err := core.AdvanceClockToImage(); err != nil {
+				audit.Println("Error setting the clock:", err)
+			}
 			_ = core.Unmount(core.RestorePath)
-			return nil
+			core.CheckRestoreProtected()
+			return Result{}, nil
 		}
 	}
 
@@ -47,22 +72,62 @@ func CheckAndRun(check bool) error {
 }
 
 // Run executes the backup of the initial writable partition and system-boot data
-func Run() error {
+func Run() (Result, error) {
 	audit.Println("Create the recovery image")
-	// TODO: Set the clock to image creation time so we are not too far off
+
+	// Allow the restore partition to be written
+	if _, err := core.UnprotectRestore(); err != nil {
+		audit.Println("Cannot lift the write protection of the restore partition:", err)
+	}
 
 	// Back up writable
 	audit.Println("Backup the writable partition")
-	if err := backupWritable(); err != nil {
-		return err
+	newest, err := backupWritable()
+	if err != nil {
+		return Result{}, err
 	}
+	metrics.Phase("writable")
 
-	// Back up system-boot
-	audit.Println("Backup the system boot partition")
-	if err := backupSystemBoot(); err != nil {
-		return err
+	// Back up system-boot, unless ubuntu-boot is kept
+	var systemBoot core.Digest
+	if core.SystemBootImaged() {
+		audit.Println("Backup the system boot partition")
+		if systemBoot, err = backupSystemBoot(); err != nil {
+			return Result{}, err
+		}
+	}
+
+	// Back up the other partitions of the Ubuntu Core 20 layout
+	if err := backupRoles(); err != nil {
+		return Result{}, err
+	}
+	metrics.Phase("boot")
+
+	// Set the clock to image creation time so we are not too far off
+	created := time.Now()
+	if newest.After(created) {
+		created = newest
+		if err := core.AdvanceClock(created); err != nil {
+			audit.Println("Error setting the clock:", err)
+		}
+	}
+	m := manifest.New(created)
+
+	// Back up the A/B boot slots and record the active slot
+	if err := backupSlots(m); err != nil {
+		return Result{}, err
+	}
+	metrics.Phase("slots")
+
+	// Record the creation time and the checksums for the factory reset
+	if core.SystemBootImaged() {
+		m.SystemBoot = &manifest.Image{Size: systemBoot.Size, SHA256: systemBoot.SHA256}
+	}
+	m.WritableSums = filepath.Base(core.SumsFile(core.BackupImageWritable))
+	if err := writeManifest(m); err != nil {
+		return Result{}, err
 	}
 
-	// # mark superblock of restore partition readonly
-	return nil
+	// Mark the superblock of the restore partition read-only
+	return Result{Created: true}, core.ProtectRestore()
 }
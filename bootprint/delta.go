@@ -0,0 +1,223 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+// Flashback
+// Copyright 2018 Canonical Ltd.  All rights reserved.
+
+package bootprint
+
+import (
+	"archive/tar"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/CanonicalLtd/flashback/audit"
+	"github.com/CanonicalLtd/flashback/config"
+	"github.com/CanonicalLtd/flashback/core"
+	"github.com/CanonicalLtd/flashback/manifest"
+	"github.com/CanonicalLtd/flashback/metrics"
+)
+
+// baseArchive is the index and the file checksums of the full writable archive
+type baseArchive struct {
+	index map[string]*tar.Header
+	sums  map[string]string
+}
+
+// RunDelta saves the files of writable that changed since the full archive
+// as a new generation of the recovery image
+func RunDelta() (Result, error) {
+	result, err := runDelta()
+	return result, core.NewError(core.FailureBootprint, err)
+}
+
+func runDelta() (Result, error) {
+	audit.Println("Create a delta generation of the recovery image")
+
+	// Find the partition devices
+	if err := core.FindPartitions(); err != nil {
+		return Result{}, err
+	}
+
+	m, base, err := readBase()
+	if err != nil {
+		return Result{}, err
+	}
+
+	// Allow the restore partition to be written
+	if _, err := core.UnprotectRestore(); err != nil {
+		audit.Println("Cannot lift the write protection of the restore partition:", err)
+	}
+
+	g, newest, err := backupWritableDelta(m, base)
+	if err != nil {
+		return Result{}, err
+	}
+	metrics.Phase("delta")
+
+	// Set the clock to the generation creation time so we are not too far off
+	created := time.Now()
+	if newest.After(created) {
+		created = newest
+		if err := core.AdvanceClock(created); err != nil {
+			audit.Println("Error setting the clock:", err)
+		}
+	}
+
+	if err := writeGenerations(m, g, created); err != nil {
+		return Result{Generation: g}, err
+	}
+
+	// Mark the superblock of the restore partition read-only
+	return Result{Created: true, Generation: g}, core.ProtectRestore()
+}
+
+// readBase reads the manifest, and the index and the checksums of the full
+// writable archive
+func readBase() (*manifest.Manifest, baseArchive, error) {
+	if err := core.MountReadOnly(core.PartitionTable.Restore, core.RestorePath); err != nil {
+		return nil, baseArchive{}, core.NewError(core.FailureBootprint, err)
+	}
+	defer core.Unmount(core.RestorePath)
+
+	created, err := manifest.ImageTime(core.ManifestFile, core.BackupImageWritable)
+	if err != nil {
+		audit.Println("A delta needs a full recovery image, run bootprint first")
+		return nil, baseArchive{}, core.NewError(core.FailureImageMissing, err)
+	}
+
+	// Images created without a manifest get one
+	m, err := manifest.Read(core.ManifestFile)
+	if os.IsNotExist(err) {
+		m, err = manifest.New(created), nil
+	}
+	if err != nil {
+		return nil, baseArchive{}, err
+	}
+
+	index, err := core.ArchiveIndex(core.BackupImageWritable)
+	if err != nil {
+		return nil, baseArchive{}, core.NewError(core.FailureImageCorrupt, err)
+	}
+	sums, err := readBaseSums()
+	if err != nil {
+		return nil, baseArchive{}, core.NewError(core.FailureImageCorrupt, err)
+	}
+	return m, baseArchive{index: index, sums: sums}, nil
+}
+
+// readBaseSums reads the file checksums of the full writable archive. An
+// archive without them is compared by the metadata of the files
+func readBaseSums() (map[string]string, error) {
+	f, err := os.Open(core.SumsFile(core.BackupImageWritable))
+	if os.IsNotExist(err) {
+		audit.Println("No checksums of the full archive, the files are compared by their metadata")
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return core.ReadSums(f)
+}
+
+// backupWritableDelta writes the delta archive of the next generation.
+// Returns the number of the generation and the newest modification time of the files
+func backupWritableDelta(m *manifest.Manifest, base baseArchive) (int, time.Time, error) {
+	g := m.Next()
+	archive := core.DeltaArchive(g)
+	audit.Printf("Backup the changes to writable to %s\n", archive)
+
+	if err := core.Mount(core.PartitionTable.Writable, core.WritablePath); err != nil {
+		return 0, time.Time{}, err
+	}
+	defer core.Unmount(core.WritablePath)
+
+	if err := core.Mount(core.PartitionTable.Restore, core.RestorePath); err != nil {
+		return 0, time.Time{}, err
+	}
+	defer core.Unmount(core.RestorePath)
+
+	source := filepath.Join(core.WritablePath, core.SystemData)
+	newest, err := core.NewestModTime(source)
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+
+	// Write to temporary files, so a partial delta is not left behind
+	tmp := archive + ".partial"
+	tmpSums := core.SumsFile(archive) + ".partial"
+	changed, deleted, err := writeDelta(tmp, tmpSums, source, base)
+	if err != nil {
+		_ = os.Remove(tmp)
+		_ = os.Remove(tmpSums)
+		return 0, time.Time{}, err
+	}
+	if err := os.Rename(tmpSums, core.SumsFile(archive)); err != nil {
+		return 0, time.Time{}, err
+	}
+	if err := os.Rename(tmp, archive); err != nil {
+		return 0, time.Time{}, err
+	}
+
+	audit.Printf("Delta generation %d: %d changed and %d deleted entries\n", g, changed, deleted)
+	return g, newest, nil
+}
+
+// writeDelta writes the delta archive and the checksums of its files, and
+// flushes them to the disk
+func writeDelta(path, sumsPath, source string, base baseArchive) (int, int, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer f.Close()
+
+	sums, err := os.Create(sumsPath)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer sums.Close()
+
+	gw := core.NewGzipWriter(f, config.Store.Compression.Workers)
+	// Closing again is harmless, and stops the workers on an error
+	defer gw.Close()
+	tw := tar.NewWriter(gw)
+
+	changed, deleted, err := core.TarDelta(source, base.index, base.sums, tw, sums)
+	if err != nil {
+		return 0, 0, err
+	}
+	if err := tw.Close(); err != nil {
+		return 0, 0, err
+	}
+	if err := gw.Close(); err != nil {
+		return 0, 0, err
+	}
+	if err := sums.Sync(); err != nil {
+		return 0, 0, err
+	}
+	return changed, deleted, f.Sync()
+}
+
+// writeGenerations records the new generation in the manifest and removes
+// the oldest generations beyond the configured number
+func writeGenerations(m *manifest.Manifest, g int, created time.Time) error {
+	if err := core.Mount(core.PartitionTable.Restore, core.RestorePath); err != nil {
+		return err
+	}
+	defer core.Unmount(core.RestorePath)
+
+	archive := core.DeltaArchive(g)
+	m.AddGeneration(g, filepath.Base(archive), filepath.Base(core.SumsFile(archive)), created)
+	removed := m.Prune(config.Store.Generations.Keep)
+	if err := m.Write(core.ManifestFile); err != nil {
+		return err
+	}
+
+	for _, old := range removed {
+		audit.Printf("Remove delta generation %d\n", old.Number)
+		removeDelta(old.Number)
+	}
+	return nil
+}
@@ -0,0 +1,47 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+// Flashback
+// Copyright 2018 Canonical Ltd.  All rights reserved.
+
+package bootprint
+
+import (
+	"github.com/CanonicalLtd/flashback/audit"
+	"github.com/CanonicalLtd/flashback/config"
+	"github.com/CanonicalLtd/flashback/core"
+)
+
+// backupRoles makes backups of the partitions of the Ubuntu Core 20 layout
+// besides ubuntu-boot and ubuntu-data, as images or archives
+func backupRoles() error {
+	for _, r := range core.PartitionTable.ExtraRoles() {
+		audit.Printf("Backup the %s partition to %s\n", r.Name, r.Backup())
+
+		var err error
+		switch r.Action {
+		case config.RoleImage:
+			_, err = backupPartition(r.Device, r.Backup())
+		case config.RoleArchive:
+			err = backupRoleArchive(r)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// backupRoleArchive archives all the files of a partition. The entries are
+// under a directory named after the role
+func backupRoleArchive(r core.Role) error {
+	if err := core.MountReadOnly(r.Device, r.MountPath()); err != nil {
+		return err
+	}
+	defer core.Unmount(r.MountPath())
+
+	if err := core.Mount(core.PartitionTable.Restore, core.RestorePath); err != nil {
+		return err
+	}
+	defer core.Unmount(core.RestorePath)
+
+	return writeArchive(r.Backup(), r.MountPath())
+}
@@ -0,0 +1,66 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+// Flashback
+// Copyright 2018 Canonical Ltd.  All rights reserved.
+
+package bootprint
+
+import (
+	"os"
+
+	"github.com/CanonicalLtd/flashback/audit"
+	"github.com/CanonicalLtd/flashback/config"
+	"github.com/CanonicalLtd/flashback/core"
+	"github.com/CanonicalLtd/flashback/manifest"
+)
+
+// backupSlots images the A/B boot slots: every slot, or only the active one
+// if that is all a factory reset restores. The active slot is recorded in
+// the manifest, with the checksums of the images
+func backupSlots(m *manifest.Manifest) error {
+	if len(core.PartitionTable.Slots) == 0 {
+		return nil
+	}
+
+	active, err := core.ActiveSlot()
+	if err != nil {
+		audit.Println("Cannot find the active boot slot:", err)
+		return err
+	}
+	audit.Printf("The active boot slot is `%s`\n", active.Name)
+
+	slots := core.PartitionTable.Slots
+	if config.Store.Slots.Restore == config.SlotsActive {
+		slots = []core.Slot{active}
+	}
+
+	m.ActiveSlot = active.Name
+	m.Slots = map[string]*manifest.Image{}
+	for _, s := range slots {
+		audit.Printf("Backup the boot slot `%s` to %s\n", s.Name, s.Image())
+		digest, err := backupPartition(s.Device, s.Image())
+		if err != nil {
+			return err
+		}
+		m.Slots[s.Name] = &manifest.Image{Size: digest.Size, SHA256: digest.SHA256}
+	}
+	return nil
+}
+
+// slotsBackedUp checks that the images of the boot slots in the manifest
+// exist. The restore partition must be mounted
+func slotsBackedUp() bool {
+	m, err := manifest.Read(core.ManifestFile)
+	if err != nil || len(m.ActiveSlot) == 0 {
+		return false
+	}
+	for name := range m.Slots {
+		s, ok := core.PartitionTable.Slot(name)
+		if !ok {
+			return false
+		}
+		if _, err := os.Stat(s.Image()); err != nil {
+			return false
+		}
+	}
+	return len(m.Slots) > 0
+}
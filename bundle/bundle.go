@@ -0,0 +1,214 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+// Flashback
+// Copyright 2018 Canonical Ltd.  All rights reserved.
+
+package bundle
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// FormatVersion is the version of the bundle format
+const FormatVersion = 1
+
+// IndexName is the name of the index in the bundle, which is the first entry
+const IndexName = "bundle.yaml"
+
+// Index describes the files in a bundle
+type Index struct {
+	Format  int    `yaml:"format"`
+	Created string `yaml:"created"`
+	Files   []File `yaml:"files"`
+}
+
+// File is a file in the bundle, with its size and SHA-256 checksum
+type File struct {
+	Name   string `yaml:"name"`
+	Size   int64  `yaml:"size"`
+	SHA256 string `yaml:"sha256"`
+}
+
+// Entry is a file on disk to add to the bundle under a name
+type Entry struct {
+	Name string
+	Path string
+}
+
+// Write packs the files into a bundle. The bundle is a tar file with the
+// index first, followed by the files in the order of the index
+func Write(w io.Writer, entries []Entry) error {
+	index := Index{
+		Format:  FormatVersion,
+		Created: time.Now().UTC().Format(time.RFC3339),
+	}
+
+	// Checksum the files, so the index can go first
+	for _, e := range entries {
+		f, err := checksumFile(e)
+		if err != nil {
+			return err
+		}
+		index.Files = append(index.Files, f)
+	}
+
+	dat, err := yaml.Marshal(&index)
+	if err != nil {
+		return err
+	}
+
+	tw := tar.NewWriter(w)
+	now := time.Now()
+	hdr := &tar.Header{Name: IndexName, Mode: 0644, Size: int64(len(dat)), ModTime: now, Typeflag: tar.TypeReg}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	if _, err := tw.Write(dat); err != nil {
+		return err
+	}
+
+	for i, e := range entries {
+		if err := writeFile(tw, e, index.Files[i]); err != nil {
+			return err
+		}
+	}
+
+	return tw.Close()
+}
+
+// Read unpacks a bundle, passing each file to the handler. The size and
+// checksum of each file are checked after it is handled, so the handler must
+// not commit to a file until the whole bundle has been read
+func Read(r io.Reader, handle func(f File, r io.Reader) error) (*Index, error) {
+	tr := tar.NewReader(r)
+
+	hdr, err := tr.Next()
+	if err != nil {
+		return nil, fmt.Errorf("cannot read the bundle index: %v", err)
+	}
+	if hdr.Name != IndexName {
+		return nil, fmt.Errorf("bundle does not start with `%s`", IndexName)
+	}
+
+	index := &Index{}
+	dat, err := readAll(tr, hdr.Size)
+	if err != nil {
+		return nil, err
+	}
+	if err := yaml.Unmarshal(dat, index); err != nil {
+		return nil, fmt.Errorf("invalid bundle index: %v", err)
+	}
+	if index.Format != FormatVersion {
+		return nil, fmt.Errorf("bundle format %d is not supported, expected %d", index.Format, FormatVersion)
+	}
+
+	for _, f := range index.Files {
+		hdr, err := tr.Next()
+		if err != nil {
+			return nil, fmt.Errorf("missing `%s` in the bundle: %v", f.Name, err)
+		}
+		if hdr.Name != f.Name {
+			return nil, fmt.Errorf("unexpected `%s` in the bundle, expected `%s`", hdr.Name, f.Name)
+		}
+
+		h := sha256.New()
+		counter := &countWriter{}
+		if err := handle(f, io.TeeReader(tr, io.MultiWriter(h, counter))); err != nil {
+			return nil, err
+		}
+
+		// Read anything the handler skipped, so it is checked
+		if _, err := io.Copy(io.MultiWriter(h, counter), tr); err != nil {
+			return nil, err
+		}
+		if counter.n != f.Size {
+			return nil, fmt.Errorf("`%s` is %d bytes, expected %d", f.Name, counter.n, f.Size)
+		}
+		if sum := hex.EncodeToString(h.Sum(nil)); sum != f.SHA256 {
+			return nil, fmt.Errorf("checksum mismatch for `%s`", f.Name)
+		}
+	}
+
+	return index, nil
+}
+
+// Has checks whether the bundle has a file
+func (index *Index) Has(name string) bool {
+	for _, f := range index.Files {
+		if f.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func checksumFile(e Entry) (File, error) {
+	f, err := os.Open(e.Path)
+	if err != nil {
+		return File{}, err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	n, err := io.Copy(h, f)
+	if err != nil {
+		return File{}, err
+	}
+	return File{Name: e.Name, Size: n, SHA256: hex.EncodeToString(h.Sum(nil))}, nil
+}
+
+func writeFile(tw *tar.Writer, e Entry, bf File) error {
+	f, err := os.Open(e.Path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	hdr := &tar.Header{Name: e.Name, Mode: 0644, Size: bf.Size, ModTime: info.ModTime(), Typeflag: tar.TypeReg}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+
+	// The file must not have changed since it was checksummed
+	h := sha256.New()
+	if _, err := io.Copy(tw, io.TeeReader(f, h)); err != nil {
+		return fmt.Errorf("`%s` changed while writing the bundle: %v", e.Path, err)
+	}
+	if hex.EncodeToString(h.Sum(nil)) != bf.SHA256 {
+		return fmt.Errorf("`%s` changed while writing the bundle", e.Path)
+	}
+	return nil
+}
+
+// readAll reads a small entry, such as the index
+func readAll(r io.Reader, size int64) ([]byte, error) {
+	const maxIndexSize = 1 << 20
+	if size > maxIndexSize {
+		return nil, fmt.Errorf("bundle index is too large: %d bytes", size)
+	}
+	dat := make([]byte, size)
+	_, err := io.ReadFull(r, dat)
+	return dat, err
+}
+
+// countWriter counts the bytes that are written
+type countWriter struct {
+	n int64
+}
+
+func (c *countWriter) Write(p []byte) (int, error) {
+	c.n += int64(len(p))
+	return len(p), nil
+}
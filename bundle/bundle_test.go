@@ -0,0 +1,208 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+// Flashback
+// Copyright 2018 Canonical Ltd.  All rights reserved.
+
+package bundle_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/CanonicalLtd/flashback/bundle"
+	"github.com/CanonicalLtd/flashback/core"
+	check "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { check.TestingT(t) }
+
+type bundleSuite struct{}
+
+var _ = check.Suite(&bundleSuite{})
+
+func (s *bundleSuite) writeBundle(c *check.C, files map[string]string) *bytes.Buffer {
+	dir := c.MkDir()
+	entries := []bundle.Entry{}
+	for _, name := range []string{"manifest.yaml", "writable.tar.gz", "system-boot.img.gz"} {
+		if _, ok := files[name]; !ok {
+			continue
+		}
+		path := filepath.Join(dir, name)
+		err := ioutil.WriteFile(path, []byte(files[name]), 0644)
+		c.Assert(err, check.IsNil)
+		entries = append(entries, bundle.Entry{Name: name, Path: path})
+	}
+
+	buf := &bytes.Buffer{}
+	err := bundle.Write(buf, entries)
+	c.Assert(err, check.IsNil)
+	return buf
+}
+
+func (s *bundleSuite) TestWriteRead(c *check.C) {
+	files := map[string]string{
+		"manifest.yaml":      "version: 1\n",
+		"writable.tar.gz":    "writable",
+		"system-boot.img.gz": "system-boot",
+	}
+	buf := s.writeBundle(c, files)
+
+	read := map[string]string{}
+	index, err := bundle.Read(buf, func(f bundle.File, r io.Reader) error {
+		dat, err := ioutil.ReadAll(r)
+		read[f.Name] = string(dat)
+		return err
+	})
+	c.Assert(err, check.IsNil)
+	c.Assert(index.Format, check.Equals, bundle.FormatVersion)
+	c.Assert(index.Files, check.HasLen, 3)
+	c.Assert(index.Has("writable.tar.gz"), check.Equals, true)
+	c.Assert(read, check.DeepEquals, files)
+}
+
+func (s *bundleSuite) TestReadCorrupt(c *check.C) {
+	buf := s.writeBundle(c, map[string]string{"writable.tar.gz": "writable", "system-boot.img.gz": "system-boot"})
+
+	// Flip a byte of the writable archive
+	dat := buf.Bytes()
+	i := bytes.Index(dat, []byte("writable\x00"))
+	c.Assert(i > 0, check.Equals, true)
+	dat[i] = 'W'
+
+	_, err := bundle.Read(bytes.NewReader(dat), func(f bundle.File, r io.Reader) error {
+		return nil
+	})
+	c.Assert(err, check.ErrorMatches, "checksum mismatch for `writable.tar.gz`")
+}
+
+func (s *bundleSuite) TestReadNotBundle(c *check.C) {
+	_, err := bundle.Read(bytes.NewReader([]byte("not a bundle")), func(f bundle.File, r io.Reader) error {
+		return nil
+	})
+	c.Assert(err, check.ErrorMatches, "cannot read the bundle index: .*")
+}
+
+func gzipped(c *check.C, content []byte) []byte {
+	buf := bytes.Buffer{}
+	gw := gzip.NewWriter(&buf)
+	_, err := gw.Write(content)
+	c.Assert(err, check.IsNil)
+	c.Assert(gw.Close(), check.IsNil)
+	return buf.Bytes()
+}
+
+func tarGz(c *check.C) []byte {
+	buf := bytes.Buffer{}
+	tw := tar.NewWriter(&buf)
+	err := tw.WriteHeader(&tar.Header{Name: "system-data/file", Mode: 0644, Size: 4, Typeflag: tar.TypeReg})
+	c.Assert(err, check.IsNil)
+	_, err = tw.Write([]byte("data"))
+	c.Assert(err, check.IsNil)
+	c.Assert(tw.Close(), check.IsNil)
+	return gzipped(c, buf.Bytes())
+}
+
+func (s *bundleSuite) TestVerify(c *check.C) {
+	writable := string(tarGz(c))
+	systemBoot := string(gzipped(c, []byte("system-boot")))
+
+	tests := []struct {
+		files map[string]string
+		err   string
+	}{
+		{map[string]string{"manifest.yaml": "version: 1\ncreated: 2018-06-01T10:00:00Z\n", "writable.tar.gz": writable, "system-boot.img.gz": systemBoot}, ""},
+		{map[string]string{"manifest.yaml": "version: 1\ncreated: yesterday\n", "writable.tar.gz": writable, "system-boot.img.gz": systemBoot}, "`manifest.yaml`: .*"},
+		{map[string]string{"writable.tar.gz": "not gzip", "system-boot.img.gz": systemBoot}, "`writable.tar.gz`: .*"},
+		{map[string]string{"writable.tar.gz": writable, "system-boot.img.gz": systemBoot[:len(systemBoot)/2]}, "`system-boot.img.gz`: .*"},
+	}
+
+	for _, t := range tests {
+		path := filepath.Join(c.MkDir(), "device.bundle")
+		err := ioutil.WriteFile(path, s.writeBundle(c, t.files).Bytes(), 0644)
+		c.Assert(err, check.IsNil)
+
+		err = bundle.Verify(path)
+		if len(t.err) == 0 {
+			c.Assert(err, check.IsNil)
+		} else {
+			c.Assert(err, check.ErrorMatches, t.err)
+		}
+	}
+}
+
+func (s *bundleSuite) TestImportFailures(c *check.C) {
+	err := bundle.Import(filepath.Join(c.MkDir(), "missing.bundle"))
+	c.Assert(core.FailureOf(err), check.Equals, core.FailureImageMissing)
+
+	path := filepath.Join(c.MkDir(), "device.bundle")
+	c.Assert(ioutil.WriteFile(path, []byte("not a bundle"), 0644), check.IsNil)
+	err = bundle.Import(path)
+	c.Assert(core.FailureOf(err), check.Equals, core.FailureImageCorrupt)
+}
+
+func (s *bundleSuite) TestReplaceFilesRollback(c *check.C) {
+	dir := c.MkDir()
+	manifestFile := core.ManifestFile
+	core.ManifestFile = filepath.Join(dir, "manifest.yaml")
+	defer func() { core.ManifestFile = manifestFile }()
+
+	writable := filepath.Join(dir, "writable.tar.gz")
+	systemBoot := filepath.Join(dir, "system-boot.img.gz")
+	delta := filepath.Join(dir, "writable-1.tar.gz")
+	for _, path := range []string{core.ManifestFile, writable, systemBoot} {
+		c.Assert(ioutil.WriteFile(path, []byte("old"), 0644), check.IsNil)
+	}
+	written := []string{core.ManifestFile, writable, delta, systemBoot}
+	for _, path := range written {
+		c.Assert(ioutil.WriteFile(path+".import", []byte("new"), 0644), check.IsNil)
+	}
+
+	// Moving system-boot into place fails, after writable and the delta
+	moved := []string{}
+	restore := bundle.MockRename(func(oldpath, newpath string) error {
+		if oldpath == systemBoot+".import" {
+			return errors.New("no space left on device")
+		}
+		if newpath != oldpath+".previous" {
+			moved = append(moved, newpath)
+		}
+		return os.Rename(oldpath, newpath)
+	})
+	defer restore()
+
+	err := bundle.ReplaceFiles(written)
+	c.Assert(err, check.ErrorMatches, "no space left on device")
+	c.Assert(moved[:2], check.DeepEquals, []string{writable, delta})
+
+	// The previous recovery image is back, and nothing of the import is left
+	for _, path := range []string{core.ManifestFile, writable, systemBoot} {
+		dat, err := ioutil.ReadFile(path)
+		c.Assert(err, check.IsNil)
+		c.Assert(string(dat), check.Equals, "old", check.Commentf(path))
+	}
+	files, err := filepath.Glob(filepath.Join(dir, "*"))
+	c.Assert(err, check.IsNil)
+	c.Assert(files, check.HasLen, 3)
+
+	// Without a failure, the manifest is moved last
+	restore()
+	for _, path := range written {
+		c.Assert(ioutil.WriteFile(path+".import", []byte("new"), 0644), check.IsNil)
+	}
+	c.Assert(bundle.ReplaceFiles(written), check.IsNil)
+	c.Assert(written[len(written)-1], check.Equals, core.ManifestFile)
+	for _, path := range written {
+		dat, err := ioutil.ReadFile(path)
+		c.Assert(err, check.IsNil)
+		c.Assert(string(dat), check.Equals, "new")
+	}
+	files, err = filepath.Glob(filepath.Join(dir, "*"))
+	c.Assert(err, check.IsNil)
+	c.Assert(files, check.HasLen, 4)
+}
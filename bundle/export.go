@@ -0,0 +1,134 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+// Flashback
+// Copyright 2018 Canonical Ltd.  All rights reserved.
+
+package bundle
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/CanonicalLtd/flashback/audit"
+	"github.com/CanonicalLtd/flashback/config"
+	"github.com/CanonicalLtd/flashback/core"
+	"github.com/CanonicalLtd/flashback/manifest"
+)
+
+// Names of the recovery image files in a bundle
+const (
+	NameManifest   = core.ManifestFileName
+	NameWritable   = "writable.tar.gz"
+	NameSystemBoot = "system-boot.img.gz"
+)
+
+// Export packs the recovery image on the restore partition into a bundle file
+func Export(path string) error {
+	audit.Println("Export the recovery image to", path)
+
+	// Find the partition devices
+	if err := core.FindPartitions(); err != nil {
+		return err
+	}
+
+	if err := core.MountReadOnly(core.PartitionTable.Restore, core.RestorePath); err != nil {
+		return err
+	}
+	defer core.Unmount(core.RestorePath)
+
+	entries, err := recoveryFiles()
+	if err != nil {
+		return err
+	}
+
+	// Write to a temporary file, so a partial bundle is not left behind
+	tmp := path + ".partial"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+
+	err = Write(f, entries)
+	if err == nil {
+		err = f.Sync()
+	}
+	if errClose := f.Close(); err == nil {
+		err = errClose
+	}
+	if err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+
+	if err := os.Rename(tmp, path); err != nil {
+		return err
+	}
+	audit.Printf("Exported %d files to %s\n", len(entries), path)
+	return nil
+}
+
+// recoveryFiles lists the files of the recovery image. The restore partition must be mounted
+func recoveryFiles() ([]Entry, error) {
+	entries := []Entry{}
+
+	// The manifest is missing from recovery images made by older versions
+	if _, err := os.Stat(core.ManifestFile); err == nil {
+		entries = append(entries, Entry{Name: NameManifest, Path: core.ManifestFile})
+	}
+
+	images := []Entry{{Name: NameWritable, Path: core.BackupImageWritable}}
+	if core.SystemBootImaged() {
+		images = append(images, Entry{Name: NameSystemBoot, Path: core.BackupImageSystemBoot})
+	}
+	for _, r := range core.PartitionTable.ExtraRoles() {
+		images = append(images, Entry{Name: filepath.Base(r.Backup()), Path: r.Backup()})
+	}
+	for _, e := range images {
+		if _, err := os.Stat(e.Path); err != nil {
+			return nil, core.NewError(core.FailureImageMissing, err)
+		}
+		entries = append(entries, e)
+	}
+
+	// Images of the A/B boot slots, which may only be of the active slot
+	for _, s := range core.PartitionTable.Slots {
+		if _, err := os.Stat(s.Image()); err == nil {
+			entries = append(entries, Entry{Name: filepath.Base(s.Image()), Path: s.Image()})
+		}
+	}
+
+	// Checksums of the archived partitions of the Ubuntu Core 20 layout
+	for _, r := range core.PartitionTable.ExtraRoles() {
+		if _, err := os.Stat(core.SumsFile(r.Backup())); err == nil {
+			entries = append(entries, Entry{Name: filepath.Base(core.SumsFile(r.Backup())), Path: core.SumsFile(r.Backup())})
+		}
+	}
+
+	// Checksums and delta generations of the writable archive
+	if m, err := manifest.Read(core.ManifestFile); err == nil {
+		if len(m.WritableSums) > 0 {
+			entries = append(entries, Entry{Name: core.SumsFile(NameWritable), Path: core.SumsFile(core.BackupImageWritable)})
+		}
+		for _, g := range m.Generations {
+			path := core.DeltaArchive(g.Number)
+			if _, err := os.Stat(path); err != nil {
+				return nil, core.NewError(core.FailureImageMissing, err)
+			}
+			entries = append(entries, Entry{Name: filepath.Base(path), Path: path})
+			if len(g.Sums) > 0 {
+				entries = append(entries, Entry{Name: filepath.Base(core.SumsFile(path)), Path: core.SumsFile(path)})
+			}
+		}
+	}
+
+	// Extra partitions
+	for _, r := range config.Store.Restore {
+		path := filepath.Join(core.RestorePath, r.File)
+		if _, err := os.Stat(path); err != nil {
+			audit.Printf("Backup of `%s` not found: %s\n", r.Label, r.File)
+			continue
+		}
+		entries = append(entries, Entry{Name: filepath.ToSlash(filepath.Clean(r.File)), Path: path})
+	}
+
+	return entries, nil
+}
@@ -0,0 +1,16 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+// Flashback
+// Copyright 2018 Canonical Ltd.  All rights reserved.
+
+package bundle
+
+import "os"
+
+// ReplaceFiles moves the written files of an import into place
+var ReplaceFiles = replaceFiles
+
+// MockRename replaces the function that moves the files into place
+func MockRename(f func(oldpath, newpath string) error) (restore func()) {
+	rename = f
+	return func() { rename = os.Rename }
+}
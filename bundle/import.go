@@ -0,0 +1,319 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+// Flashback
+// Copyright 2018 Canonical Ltd.  All rights reserved.
+
+package bundle
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/CanonicalLtd/flashback/audit"
+	"github.com/CanonicalLtd/flashback/config"
+	"github.com/CanonicalLtd/flashback/core"
+	"github.com/CanonicalLtd/flashback/manifest"
+)
+
+// importSuffix is added to the files while they are written to the restore
+// partition, so the recovery image is only replaced once the whole bundle is
+// written and verified. The files of the previous recovery image are kept
+// aside with previousSuffix until every new file is in place
+const (
+	importSuffix   = ".import"
+	previousSuffix = ".previous"
+)
+
+// rename moves a file into place
+var rename = os.Rename
+
+// Import provisions the restore partition from a bundle file. The bundle is
+// verified before anything is written
+func Import(path string) error {
+	audit.Println("Import the recovery image from", path)
+
+	if err := Verify(path); err != nil {
+		if os.IsNotExist(err) {
+			return core.NewError(core.FailureImageMissing, err)
+		}
+		return core.NewError(core.FailureImageCorrupt, err)
+	}
+
+	// Find the partition devices
+	if err := core.FindPartitions(); err != nil {
+		return err
+	}
+
+	// Allow the restore partition to be written
+	if _, err := core.UnprotectRestore(); err != nil {
+		audit.Println("Cannot lift the write protection of the restore partition:", err)
+	}
+
+	if err := core.Mount(core.PartitionTable.Restore, core.RestorePath); err != nil {
+		return err
+	}
+
+	err := writeRecoveryFiles(path)
+	_ = core.Unmount(core.RestorePath)
+	if err != nil {
+		return err
+	}
+
+	// Mark the superblock of the restore partition read-only
+	return core.ProtectRestore()
+}
+
+// Verify checks the checksums of a bundle file and the content of the recovery image in it
+func Verify(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	index, err := Read(f, checkFile)
+	if err != nil {
+		return err
+	}
+
+	required := []string{NameWritable}
+	if core.SystemBootImaged() {
+		required = append(required, NameSystemBoot)
+	}
+	for _, name := range required {
+		if !index.Has(name) {
+			return fmt.Errorf("`%s` is missing from the bundle", name)
+		}
+	}
+
+	audit.Printf("Bundle is valid: %d files, created %s\n", len(index.Files), index.Created)
+	return nil
+}
+
+// checkFile checks the content of a file in the bundle
+func checkFile(f File, r io.Reader) error {
+	switch f.Name {
+	case NameWritable:
+		if _, err := core.CheckTarGz(r); err != nil {
+			return fmt.Errorf("`%s`: %v", f.Name, err)
+		}
+	case NameSystemBoot:
+		if _, err := core.CheckGzip(r); err != nil {
+			return fmt.Errorf("`%s`: %v", f.Name, err)
+		}
+	case NameManifest:
+		dat, err := ioutil.ReadAll(r)
+		if err != nil {
+			return err
+		}
+		m, err := manifest.Parse(dat)
+		if err == nil {
+			_, err = m.CreatedTime()
+		}
+		if err != nil {
+			return fmt.Errorf("`%s`: %v", f.Name, err)
+		}
+	default:
+		// Delta generations, archived and imaged partitions
+		switch {
+		case strings.HasSuffix(f.Name, ".tar.gz"):
+			if _, err := core.CheckTarGz(r); err != nil {
+				return fmt.Errorf("`%s`: %v", f.Name, err)
+			}
+		case strings.HasSuffix(f.Name, ".img.gz"):
+			if _, err := core.CheckGzip(r); err != nil {
+				return fmt.Errorf("`%s`: %v", f.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// writeRecoveryFiles writes the files of the bundle to the restore partition.
+// The restore partition must be mounted read-write
+func writeRecoveryFiles(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	targets := recoveryTargets()
+	written := []string{}
+	removeWritten := func() {
+		for _, w := range written {
+			_ = os.Remove(w + importSuffix)
+		}
+	}
+
+	index, err := Read(f, func(bf File, r io.Reader) error {
+		target, ok := targets[bf.Name]
+		if !ok {
+			audit.Printf("Skip `%s`, it is not in the restore list\n", bf.Name)
+			return nil
+		}
+
+		// The manifest comes first, and lists the delta generations that follow
+		if bf.Name == NameManifest {
+			dat, err := ioutil.ReadAll(r)
+			if err != nil {
+				return err
+			}
+			if err := addGenerationTargets(targets, dat); err != nil {
+				return err
+			}
+			r = bytes.NewReader(dat)
+		}
+
+		audit.Printf("Write `%s` to %s\n", bf.Name, target)
+		written = append(written, target)
+		return saveFile(target+importSuffix, r)
+	})
+	if err != nil {
+		removeWritten()
+		return err
+	}
+
+	// The deltas of the previous recovery image no longer apply
+	previous, _ := manifest.Read(core.ManifestFile)
+
+	// Replace the recovery image now that every file is written
+	if err := replaceFiles(written); err != nil {
+		return err
+	}
+
+	if previous != nil {
+		for _, g := range previous.Generations {
+			path := core.DeltaArchive(g.Number)
+			if targets[filepath.Base(path)] != path {
+				_ = os.Remove(path)
+				_ = os.Remove(core.SumsFile(path))
+			}
+		}
+	}
+
+	// Record the import time when the bundle does not have a manifest
+	if !index.Has(NameManifest) {
+		if err := manifest.New(time.Now()).Write(core.ManifestFile); err != nil {
+			return err
+		}
+	}
+
+	audit.Printf("Imported %d files\n", len(written))
+	return nil
+}
+
+// replaceFiles moves the written files into place, the manifest last so a
+// reset never sees a manifest without its files. If a file cannot be moved,
+// the files of the previous recovery image are put back and the written
+// files are removed
+func replaceFiles(written []string) error {
+	sort.SliceStable(written, func(i, j int) bool {
+		return written[i] != core.ManifestFile && written[j] == core.ManifestFile
+	})
+
+	replaced := []string{}
+	aside := map[string]bool{}
+	rollback := func() {
+		for i := len(replaced) - 1; i >= 0; i-- {
+			if w := replaced[i]; aside[w] {
+				_ = rename(w+previousSuffix, w)
+			} else {
+				_ = os.Remove(w)
+			}
+		}
+		for _, w := range written {
+			_ = os.Remove(w + importSuffix)
+		}
+	}
+
+	for _, w := range written {
+		if _, err := os.Lstat(w); err == nil {
+			if err := rename(w, w+previousSuffix); err != nil {
+				rollback()
+				return err
+			}
+			aside[w] = true
+		}
+		if err := rename(w+importSuffix, w); err != nil {
+			audit.Printf("Error replacing %s, the previous recovery image is put back\n", w)
+			if aside[w] {
+				_ = rename(w+previousSuffix, w)
+			}
+			rollback()
+			return err
+		}
+		replaced = append(replaced, w)
+	}
+
+	for w := range aside {
+		_ = os.Remove(w + previousSuffix)
+	}
+	return nil
+}
+
+// recoveryTargets maps the names of the files in a bundle to their paths on
+// the restore partition
+func recoveryTargets() map[string]string {
+	targets := map[string]string{
+		NameManifest:   core.ManifestFile,
+		NameWritable:   core.BackupImageWritable,
+		NameSystemBoot: core.BackupImageSystemBoot,
+	}
+	for _, r := range core.PartitionTable.ExtraRoles() {
+		targets[filepath.Base(r.Backup())] = r.Backup()
+		targets[filepath.Base(core.SumsFile(r.Backup()))] = core.SumsFile(r.Backup())
+	}
+	for _, s := range core.PartitionTable.Slots {
+		targets[filepath.Base(s.Image())] = s.Image()
+	}
+	for _, r := range config.Store.Restore {
+		targets[filepath.ToSlash(filepath.Clean(r.File))] = filepath.Join(core.RestorePath, r.File)
+	}
+	return targets
+}
+
+// addGenerationTargets adds the checksums and the delta archives of the
+// generations in a manifest to the targets
+func addGenerationTargets(targets map[string]string, dat []byte) error {
+	m, err := manifest.Parse(dat)
+	if err != nil {
+		return err
+	}
+	if len(m.WritableSums) > 0 {
+		targets[core.SumsFile(NameWritable)] = core.SumsFile(core.BackupImageWritable)
+	}
+	for _, g := range m.Generations {
+		path := core.DeltaArchive(g.Number)
+		targets[filepath.Base(path)] = path
+		targets[filepath.Base(core.SumsFile(path))] = core.SumsFile(path)
+	}
+	return nil
+}
+
+// saveFile writes a file and flushes it to the disk
+func saveFile(path string, r io.Reader) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
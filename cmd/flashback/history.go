@@ -0,0 +1,99 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+// Flashback
+// Copyright 2018 Canonical Ltd.  All rights reserved.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/CanonicalLtd/flashback/audit"
+	"github.com/CanonicalLtd/flashback/core"
+	"github.com/CanonicalLtd/flashback/execute"
+	"github.com/CanonicalLtd/flashback/history"
+)
+
+// Triggers of a run that are recorded in the history
+const (
+	triggerCommand = "command line"
+	triggerAuto    = "auto"
+)
+
+// recordHistory appends an entry to the history on the restore partition.
+// The write protection of the restore partition is lifted for the write,
+// and put back if it was set
+func recordHistory(e history.Entry) {
+	if len(core.PartitionTable.Restore) == 0 {
+		audit.Println("Cannot record the history: the restore partition was not found")
+		return
+	}
+
+	err := core.ChangeRestore(func() error {
+		return history.Append(core.HistoryFile, e)
+	})
+	if err != nil {
+		audit.Println("Cannot record the history:", err)
+	}
+}
+
+// runHistory prints the bootprints and factory resets in the history on the
+// restore partition, oldest first. Nothing on the device is changed: the
+// restore partition is mounted read-only, and the log is only written to
+// stderr so stdout holds the report alone
+func runHistory() error {
+	if err := core.FindPartitions(); err != nil {
+		return err
+	}
+
+	if err := core.MountReadOnly(core.PartitionTable.Restore, core.RestorePath); err != nil {
+		audit.Println("Error mounting the restore partition:", err)
+		return err
+	}
+	entries, skipped, err := history.Read(core.HistoryFile)
+	_ = core.Unmount(core.RestorePath)
+	if os.IsNotExist(err) {
+		if !execute.Execution.History.JSON {
+			fmt.Println("No history on the restore partition")
+		}
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if execute.Execution.History.JSON {
+		enc := json.NewEncoder(os.Stdout)
+		for _, e := range entries {
+			if err := enc.Encode(e); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	resets := 0
+	for _, e := range entries {
+		if e.Command == execute.CommandReset {
+			resets++
+		}
+		fmt.Printf("%s  %-9s  %-7s  generation %d", e.Time, e.Command, e.Outcome, e.Generation)
+		if len(e.Trigger) > 0 {
+			fmt.Printf("  by %s", e.Trigger)
+		}
+		fmt.Println()
+		if len(e.Retained) > 0 {
+			fmt.Printf("    retained: %s\n", strings.Join(e.Retained, ", "))
+		}
+		if len(e.Error) > 0 {
+			fmt.Printf("    error: %s\n", e.Error)
+		}
+	}
+	fmt.Printf("%d entries, %d factory resets\n", len(entries), resets)
+	if skipped > 0 {
+		fmt.Printf("%d entries cannot be read\n", skipped)
+	}
+	return nil
+}
@@ -5,63 +5,283 @@
 package main
 
 import (
+	"fmt"
 	"os"
+	"strings"
+	"time"
 
 	"github.com/CanonicalLtd/flashback/audit"
 	"github.com/CanonicalLtd/flashback/core"
 
 	"github.com/CanonicalLtd/flashback/bootprint"
+	"github.com/CanonicalLtd/flashback/bundle"
 	"github.com/CanonicalLtd/flashback/config"
 	"github.com/CanonicalLtd/flashback/execute"
+	"github.com/CanonicalLtd/flashback/history"
 	"github.com/CanonicalLtd/flashback/reset"
+	"github.com/CanonicalLtd/flashback/trigger"
 	flags "github.com/jessevdk/go-flags"
 )
 
 func main() {
-	args, err := flags.ParseArgs(&execute.Execution, os.Args)
+	parser := flags.NewParser(&execute.Execution, flags.Default)
+	parser.SubcommandsOptional = true
+
+	_, err := parser.Parse()
 	if err != nil {
-		os.Exit(1)
+		if flagsErr, ok := err.(*flags.Error); ok && flagsErr.Type == flags.ErrHelp {
+			os.Exit(0)
+		}
+		os.Exit(core.ExitCode(core.NewError(core.FailureConfig, err)))
 	}
 
-	err = Execute(args)
+	active := ""
+	if parser.Active != nil {
+		active = parser.Active.Name
+	}
+	command, err := execute.Execution.Resolve(active)
 	if err != nil {
-		os.Exit(1)
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(core.ExitCode(core.NewError(core.FailureConfig, err)))
+	}
+
+	switch command {
+	case execute.CommandValidate:
+		err = Validate()
+	case execute.CommandConfig:
+		err = PrintConfig()
+	default:
+		err = Execute(command)
 	}
 
-	os.Exit(0)
+	os.Exit(core.ExitCode(err))
 }
 
-// Execute processes the args and runs the image restore
-func Execute(args []string) error {
+// Validate checks the config file for errors
+func Validate() error {
+	err := config.Read(execute.Execution.ConfigPath)
+	if err != nil {
+		return core.NewError(core.FailureConfig, err)
+	}
+
+	fmt.Printf("%s: config is valid\n", execute.Execution.ConfigPath)
+	return nil
+}
+
+// PrintConfig prints the effective config
+func PrintConfig() error {
+	err := config.Read(execute.Execution.ConfigPath)
+	if err != nil {
+		return core.NewError(core.FailureConfig, err)
+	}
+
+	return config.Print(os.Stdout)
+}
+
+// Execute runs a command on the device
+func Execute(command string) error {
+	// Log to the requested file from the start
+	if len(execute.Execution.LogFile) > 0 {
+		audit.LogFile = execute.Execution.LogFile
+	}
+	// Keep stdout for the report of the commands that only read
+	if command == execute.CommandStatus || command == execute.CommandHistory {
+		audit.Quiet()
+	}
+
 	// Read the config parameters
 	err := config.Read(execute.Execution.ConfigPath)
 	if err != nil {
 		audit.Println("Error reading config file:", err)
-		return err
+		return core.NewError(core.FailureConfig, err)
 	}
+	setPaths()
 
-	// Check if we need to create a boot print
-	if execute.Execution.Bootprint {
-		err = bootprint.CheckAndRun(execute.Execution.Check)
-		if err != nil {
-			audit.Println("Error in bootprint:", err)
-			retainLog(config.LogFileBootprint)
-			return err
+	switch command {
+	case execute.CommandNone:
+		return nil
+	case execute.CommandBootprint:
+		if execute.Execution.BootprintCmd.Delta {
+			return runBootprintDelta()
+		}
+		return runBootprint(execute.Execution.CheckRecovery(), triggerCommand)
+	case execute.CommandReset:
+		reset.Generation = execute.Execution.Reset.Generation
+		if execute.Execution.Reset.ReadBack {
+			config.Store.Verify.ReadBack = true
 		}
+		return runReset(triggerCommand, nil)
+	case execute.CommandAuto:
+		// Decide whether to reset or create a boot print from the trigger sources
+		return runAuto()
+	case execute.CommandVerify:
+		return runVerify()
+	case execute.CommandStatus:
+		return runStatus()
+	case execute.CommandExport:
+		return runExport()
+	case execute.CommandImport:
+		return runImport()
+	case execute.CommandHistory:
+		return runHistory()
+	default:
+		return fmt.Errorf("command `%s` is not implemented", command)
 	}
+}
 
-	// Start a factory reset, if requested
-	if execute.Execution.FactoryReset {
-		err = reset.Run()
-		if err != nil {
-			audit.Println("Error in factory reset:", err)
-			retainLog(config.LogFileReset)
-		}
+// runAuto starts a factory reset if one of the trigger sources requests it.
+// Otherwise, the recovery image is created if it does not exist
+func runAuto() error {
+	// Find the partition devices for the marker files
+	if err := core.FindPartitions(); err != nil {
+		audit.Println("Error finding the partitions:", err)
+		return err
+	}
+
+	requested := trigger.Check(trigger.Sources())
+	if len(requested) == 0 {
+		audit.Println("No factory reset is requested")
+		return runBootprint(true, triggerAuto)
+	}
+
+	// Clear the requests before the reset, so a reset that fails is not
+	// repeated on every boot. A request that cannot be cleared would reset
+	// the device on every boot, so the reset only runs for the requests
+	// that were cleared
+	cleared, err := trigger.Consume(requested)
+	if len(cleared) == 0 {
+		audit.Println("The factory reset request cannot be cleared, the factory reset is not run:", err)
+		return err
+	}
+
+	names := []string{}
+	for _, s := range cleared {
+		names = append(names, s.Name())
+	}
+	return runReset(strings.Join(names, ", "), cleared)
+}
+
+// runBootprint creates the recovery image
+func runBootprint(check bool, by string) error {
+	startMetrics(execute.CommandBootprint)
+	result, err := bootprint.CheckAndRun(check)
+	if err != nil {
+		audit.Println("Error in bootprint:", err)
+		retainLog(config.Store.Paths.BootprintLog)
+	}
+	recordMetrics(err)
+	recordBootprint(by, result, err)
+	return err
+}
+
+// runBootprintDelta saves the changes to writable as a new generation of the recovery image
+func runBootprintDelta() error {
+	startMetrics(execute.CommandBootprint)
+	result, err := bootprint.RunDelta()
+	if err != nil {
+		audit.Println("Error in bootprint:", err)
+		retainLog(config.Store.Paths.BootprintLog)
+	}
+	recordMetrics(err)
+	recordBootprint(triggerCommand, result, err)
+	return err
+}
+
+// recordBootprint records a bootprint in the history, unless the recovery
+// image already existed and nothing was done
+func recordBootprint(by string, result bootprint.Result, err error) {
+	if !result.Created && err == nil {
+		return
+	}
+	e := history.New(execute.CommandBootprint, time.Now(), err)
+	e.Trigger = by
+	e.Generation = result.Generation
+	recordHistory(e)
+}
+
+// runReset runs the factory reset. The outcome is recorded in the trigger
+// sources that requested it
+func runReset(by string, sources []trigger.Source) error {
+	startMetrics(execute.CommandReset)
+	result, err := reset.Run()
+	if err != nil {
+		audit.Println("Error in factory reset:", err)
+		retainLog(config.Store.Paths.ResetLog)
+	}
+	recordMetrics(err)
+
+	e := history.New(execute.CommandReset, time.Now(), err)
+	e.Trigger = by
+	e.Generation = result.Generation
+	e.Retained = result.Retained
+	recordHistory(e)
+
+	// Let the bootloader and the OS know how the reset went
+	trigger.Record(sources, err)
+
+	postReset(err)
+	return err
+}
+
+// runVerify checks the recovery image
+func runVerify() error {
+	err := reset.Verify()
+	if err != nil {
+		audit.Println("Error in recovery image:", err)
 	}
+	return err
+}
+
+// runExport packs the recovery image into a bundle file
+func runExport() error {
+	err := bundle.Export(execute.Execution.Export.Output)
+	if err != nil {
+		audit.Println("Error exporting the recovery image:", err)
+	}
+	return err
+}
 
+// runImport writes the recovery image from a bundle file to the restore partition
+func runImport() error {
+	err := bundle.Import(execute.Execution.Import.Input)
+	if err != nil {
+		audit.Println("Error importing the recovery image:", err)
+	}
 	return err
 }
 
+// postReset reboots, powers off or halts the device after the factory reset
+func postReset(resetErr error) {
+	action := core.PostResetAction(resetErr, config.Store.PostReset.Action, config.Store.PostReset.OnFailure, execute.Execution.PostResetAction())
+
+	if err := core.PowerAction(action, config.Store.PostReset.Delay); err != nil {
+		audit.Printf("Error running `%s` after the factory reset: %v\n", action, err)
+	}
+}
+
+// setPaths applies the paths from the command line over the config file
+func setPaths() {
+	paths := &config.Store.Paths
+	overridePath(&paths.RestoreMount, execute.Execution.RestoreMount)
+	overridePath(&paths.WritableMount, execute.Execution.WritableMount)
+	overridePath(&paths.TmpfsMount, execute.Execution.TmpfsMount)
+	overridePath(&paths.SystemBootMount, execute.Execution.SystemBootMount)
+	overridePath(&paths.WritableArchive, execute.Execution.WritableArchive)
+	overridePath(&paths.SystemBootImage, execute.Execution.SystemBootImage)
+	overridePath(&paths.Log, execute.Execution.LogFile)
+	overridePath(&paths.BootprintLog, execute.Execution.BootprintLog)
+	overridePath(&paths.ResetLog, execute.Execution.ResetLog)
+
+	audit.LogFile = paths.Log
+	core.SetPaths()
+}
+
+func overridePath(path *string, value string) {
+	if len(value) > 0 {
+		*path = value
+	}
+}
+
 func retainLog(filepath string) {
-	core.CopyFile(audit.DefaultLogFile, filepath)
+	core.CopyFile(audit.LogFile, filepath)
 }
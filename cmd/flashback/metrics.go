@@ -0,0 +1,82 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+// Flashback
+// Copyright 2018 Canonical Ltd.  All rights reserved.
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/CanonicalLtd/flashback/audit"
+	"github.com/CanonicalLtd/flashback/config"
+	"github.com/CanonicalLtd/flashback/core"
+	"github.com/CanonicalLtd/flashback/metrics"
+)
+
+// metricsPath is the path of the textfile on the mounted writable partition
+func metricsPath() string {
+	return filepath.Join(core.WritablePath, core.SystemData, config.Store.Metrics.File)
+}
+
+// startMetrics starts collecting the metrics of a command. The samples in
+// the textfile on writable are read first, as a factory reset replaces it
+func startMetrics(command string) {
+	metrics.Start(command)
+	if len(config.Store.Metrics.File) == 0 {
+		return
+	}
+
+	if err := core.FindPartitions(); err != nil {
+		return
+	}
+	if err := core.MountReadOnly(core.PartitionTable.Writable, core.WritablePath); err != nil {
+		audit.Println("Cannot read the metrics:", err)
+		return
+	}
+	defer core.Unmount(core.WritablePath)
+
+	previous, err := metrics.ReadFile(metricsPath())
+	if err != nil {
+		if !os.IsNotExist(err) {
+			audit.Println("Cannot read the metrics:", err)
+		}
+		return
+	}
+	metrics.Load(previous)
+}
+
+// recordMetrics writes the metrics of the command to the textfile on
+// writable, for the textfile collector of node_exporter
+func recordMetrics(runErr error) {
+	if len(config.Store.Metrics.File) == 0 {
+		return
+	}
+	metrics.Finish(runErr, time.Now())
+
+	if len(core.PartitionTable.Restore) > 0 {
+		if err := core.MountReadOnly(core.PartitionTable.Restore, core.RestorePath); err == nil {
+			if size, err := core.DirSize(core.RestorePath); err == nil {
+				metrics.Current.Set(metrics.RecoveryImageBytes, float64(size))
+			}
+			_ = core.Unmount(core.RestorePath)
+		}
+	}
+
+	if len(core.PartitionTable.Writable) == 0 {
+		audit.Println("Cannot write the metrics: the writable partition was not found")
+		return
+	}
+	if err := core.Mount(core.PartitionTable.Writable, core.WritablePath); err != nil {
+		audit.Println("Cannot write the metrics:", err)
+		return
+	}
+	defer core.Unmount(core.WritablePath)
+
+	if err := metrics.WriteFile(metricsPath()); err != nil {
+		audit.Println("Cannot write the metrics:", err)
+		return
+	}
+	audit.Println("Metrics written to", config.Store.Metrics.File)
+}
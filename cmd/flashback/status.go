@@ -0,0 +1,110 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+// Flashback
+// Copyright 2018 Canonical Ltd.  All rights reserved.
+
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/CanonicalLtd/flashback/core"
+	"github.com/CanonicalLtd/flashback/manifest"
+	"github.com/CanonicalLtd/flashback/trigger"
+)
+
+// runStatus shows the recovery image, any factory reset request and the
+// outcome of the last factory reset. Nothing on the device is changed, and
+// the log is only written to stderr so stdout holds the status alone
+func runStatus() error {
+	if err := core.FindPartitions(); err != nil {
+		return err
+	}
+
+	fmt.Println("Partitions:")
+	fmt.Printf("  system-boot: %s\n", core.PartitionTable.SystemBoot)
+	fmt.Printf("  restore:     %s\n", core.PartitionTable.Restore)
+	fmt.Printf("  writable:    %s\n", core.PartitionTable.Writable)
+	for _, r := range core.PartitionTable.Roles {
+		fmt.Printf("  %s: %s (%s)\n", r.Name, r.Device, r.Action)
+	}
+	for _, s := range core.PartitionTable.Slots {
+		fmt.Printf("  slot %s:      %s\n", s.Name, s.Device)
+	}
+	if len(core.PartitionTable.Slots) > 0 {
+		if active, err := core.ActiveSlot(); err == nil {
+			fmt.Printf("  active slot: %s\n", active.Name)
+		} else {
+			fmt.Printf("  active slot: unknown (%v)\n", err)
+		}
+	}
+
+	if err := printRecoveryImage(); err != nil {
+		return err
+	}
+
+	sources := trigger.Sources()
+	requested := trigger.Check(sources)
+	fmt.Println("Factory reset:")
+	fmt.Printf("  requested:   %s\n", yesNo(len(requested) > 0))
+	for _, s := range requested {
+		fmt.Printf("               by %s\n", s.Name())
+	}
+	if status, when, ok := trigger.LastReset(sources); ok {
+		fmt.Printf("  last reset:  %s at %s\n", status, when)
+	} else {
+		fmt.Println("  last reset:  unknown")
+	}
+
+	return nil
+}
+
+// printRecoveryImage shows the files of the recovery image and when it was created
+func printRecoveryImage() error {
+	readonly, err := core.IsReadOnly(core.PartitionTable.Restore)
+	if err != nil {
+		return err
+	}
+
+	if err := core.MountReadOnly(core.PartitionTable.Restore, core.RestorePath); err != nil {
+		return err
+	}
+	defer core.Unmount(core.RestorePath)
+
+	fmt.Println("Recovery image:")
+	files := []string{core.BackupImageWritable, core.BackupImageSystemBoot}
+	for _, r := range core.PartitionTable.ExtraRoles() {
+		files = append(files, r.Backup())
+	}
+	for _, s := range core.PartitionTable.Slots {
+		files = append(files, s.Image())
+	}
+	for _, f := range files {
+		if info, err := os.Stat(f); err == nil {
+			fmt.Printf("  %s: %d bytes\n", f, info.Size())
+		} else {
+			fmt.Printf("  %s: missing\n", f)
+		}
+	}
+
+	if created, err := manifest.ImageTime(core.ManifestFile, core.BackupImageWritable); err == nil {
+		fmt.Printf("  created:     %s\n", created.UTC().Format("2006-01-02 15:04:05 MST"))
+	}
+	if m, err := manifest.Read(core.ManifestFile); err == nil {
+		if len(m.ActiveSlot) > 0 {
+			fmt.Printf("  active slot: %s\n", m.ActiveSlot)
+		}
+		for _, g := range m.Generations {
+			fmt.Printf("  generation:  %d, %s, created %s\n", g.Number, g.Archive, g.Created)
+		}
+	}
+	fmt.Printf("  protected:   %s\n", yesNo(readonly))
+	return nil
+}
+
+func yesNo(b bool) string {
+	if b {
+		return "yes"
+	}
+	return "no"
+}
@@ -6,43 +6,238 @@ package config
 
 import (
 	"fmt"
+	"io"
 	"io/ioutil"
+	"os"
+	"runtime"
 
 	"github.com/CanonicalLtd/flashback/audit"
 	yaml "gopkg.in/yaml.v2"
 )
 
+// Partition defines how a partition is identified on the device. The
+// first of device, partuuid, uuid, partlabel and label that is set is
+// used to find the partition, and any others that are set must also match
+type Partition struct {
+	Device    string `yaml:"device,omitempty"`
+	Label     string `yaml:"label,omitempty"`
+	UUID      string `yaml:"uuid,omitempty"`
+	PartUUID  string `yaml:"partuuid,omitempty"`
+	PartLabel string `yaml:"partlabel,omitempty"`
+}
+
+// Marker defines a file on a partition that requests a factory reset
+type Marker struct {
+	Partition string `yaml:"partition"`
+	File      string `yaml:"file"`
+}
+
+// Snap defines the data areas of a snap to keep on a factory reset. The
+// areas are `current`, `common` or paths within them
+type Snap struct {
+	Name string   `yaml:"name"`
+	Data []string `yaml:"data"`
+}
+
+// Slot defines a boot slot of an A/B system-boot, by the name that the slot
+// selector is set to and how its partition is identified
+type Slot struct {
+	Name      string `yaml:"name"`
+	Partition `yaml:",inline"`
+}
+
+// Restore defines an extra partition and its backup file (not used)
+type Restore struct {
+	Label string `yaml:"label"`
+	File  string `yaml:"file"`
+	Type  string `yaml:"type"`
+}
+
 // Config defines the configuration parameters
 type Config struct {
+	Layout     string `yaml:"layout"`
+	Partitions struct {
+		SystemBoot Partition `yaml:"system-boot"`
+		Restore    Partition `yaml:"restore"`
+		Writable   Partition `yaml:"writable"`
+		UbuntuSeed Partition `yaml:"ubuntu-seed"`
+		UbuntuBoot Partition `yaml:"ubuntu-boot"`
+		UbuntuSave Partition `yaml:"ubuntu-save"`
+		UbuntuData Partition `yaml:"ubuntu-data"`
+	} `yaml:"partitions"`
+	Slots struct {
+		Partitions []Slot `yaml:"partitions"`
+		Selector   string `yaml:"selector"`
+		Env        string `yaml:"env"`
+		Restore    string `yaml:"restore"`
+	} `yaml:"slots"`
+	Roles struct {
+		UbuntuSeed string `yaml:"ubuntu-seed"`
+		UbuntuBoot string `yaml:"ubuntu-boot"`
+		UbuntuSave string `yaml:"ubuntu-save"`
+		UbuntuData string `yaml:"ubuntu-data"`
+	} `yaml:"roles"`
+	Paths struct {
+		RestoreMount    string `yaml:"restore-mount"`
+		WritableMount   string `yaml:"writable-mount"`
+		TmpfsMount      string `yaml:"tmpfs-mount"`
+		SystemBootMount string `yaml:"system-boot-mount"`
+		RolesMount      string `yaml:"roles-mount"`
+		WritableArchive string `yaml:"writable-archive"`
+		SystemBootImage string `yaml:"system-boot-image"`
+		Log             string `yaml:"log"`
+		BootprintLog    string `yaml:"bootprint-log"`
+		ResetLog        string `yaml:"reset-log"`
+	} `yaml:"paths"`
+	Triggers struct {
+		Cmdline string   `yaml:"cmdline"`
+		Markers []Marker `yaml:"markers"`
+	} `yaml:"triggers"`
+	UBoot struct {
+		File      string `yaml:"file"`
+		Redundant string `yaml:"redundant"`
+		Request   string `yaml:"request"`
+		Status    string `yaml:"status"`
+		Timestamp string `yaml:"timestamp"`
+	} `yaml:"uboot"`
+	Grub struct {
+		File      string `yaml:"file"`
+		Request   string `yaml:"request"`
+		Status    string `yaml:"status"`
+		Timestamp string `yaml:"timestamp"`
+		BootEntry string `yaml:"boot-entry"`
+	} `yaml:"grub"`
+	PostReset struct {
+		Action    string `yaml:"action"`
+		Delay     int    `yaml:"delay"`
+		OnFailure string `yaml:"on-failure"`
+	} `yaml:"post-reset"`
 	Backup struct {
-		Size int      `yaml:"size"`
-		Data []string `yaml:"data"`
+		Size  int      `yaml:"size"`
+		Data  []string `yaml:"data"`
+		Snaps []Snap   `yaml:"snaps"`
 	} `yaml:"retain"`
+	Restore     []Restore `yaml:"restore"`
+	Generations struct {
+		Keep int `yaml:"keep"`
+	} `yaml:"generations"`
+	Compression struct {
+		Workers int `yaml:"workers"`
+	} `yaml:"compression"`
+	Verify struct {
+		ReadBack bool `yaml:"read-back"`
+	} `yaml:"verify"`
+	Metrics struct {
+		File string `yaml:"file"`
+	} `yaml:"metrics"`
+
+	// Replace lists the lists that a drop-in file replaces, instead of appending to them
+	Replace []string `yaml:"replace,omitempty"`
 }
 
+// Partition layouts of Ubuntu Core
+const (
+	LayoutUC16 = "uc16" // system-boot and writable, also Ubuntu Core 18
+	LayoutUC20 = "uc20" // ubuntu-seed, ubuntu-boot, ubuntu-save and ubuntu-data
+)
+
+// What a factory reset does with a partition of the Ubuntu Core 20 layout
+const (
+	RoleImage   = "image"   // restore a raw image of the partition
+	RoleArchive = "archive" // format the partition and restore an archive of the files
+	RoleKeep    = "keep"    // leave the partition alone
+)
+
+// Which boot slots a factory reset restores
+const (
+	SlotsAll    = "all"    // every slot, from its image
+	SlotsActive = "active" // the slot that was active at bootprint
+)
+
 // Default constants
 const (
-	defaultBackupSize = 32
-	LogFileBootprint  = "/var/log/flashback/bootprint.log"
-	LogFileReset      = "/var/log/flashback/reset.log"
+	defaultBackupSize       = 32
+	defaultGenerationsKeep  = 3
+	DefaultRestoreMount     = "/restore"
+	DefaultWritableMount    = "/writable"
+	DefaultTmpfsMount       = "/mnt/tmprestore"
+	DefaultSystemBootMount  = "/mnt/system-boot"
+	DefaultRolesMount       = "/mnt/flashback"
+	DefaultWritableArchive  = "writable.tar.gz"
+	DefaultSystemBootImage  = "system-boot.img.gz"
+	DefaultLogFileBootprint = "/var/log/flashback/bootprint.log"
+	DefaultLogFileReset     = "/var/log/flashback/reset.log"
+	DefaultCmdlineTrigger   = "flashback.reset"
+	DefaultRequestVariable  = "flashback_reset"
+	DefaultStatusVariable   = "flashback_status"
+	DefaultTimeVariable     = "flashback_time"
+	DefaultPostResetAction  = "none"
 )
 
 // Store the stored configuration from the file
 var Store Config
 
-// Read parses the yaml config file
+// Read parses the yaml config file, followed by the drop-in files in the
+// config.d directory next to it. The environment overrides the files.
+// Problems are reported on stderr, so stdout is left to the commands
 func Read(path string) error {
 	Store = Config{}
 
-	dat, err := ioutil.ReadFile(path)
+	files, err := configFiles(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error reading config parameters: %v\n", err)
+		return err
+	}
+
+	merged := map[interface{}]interface{}{}
+	sources := []source{}
+	for _, f := range files {
+		dat, err := ioutil.ReadFile(f)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading config parameters: %v\n", err)
+			return err
+		}
+
+		// Check each file on its own, so problems are found in the right file
+		layer := Config{}
+		err = parse(f, dat, &layer)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error parsing config parameters:\n%v\n", err)
+			return err
+		}
+		tree := map[interface{}]interface{}{}
+		if err := yaml.Unmarshal(dat, &tree); err != nil {
+			return err
+		}
+		src := source{path: f, tree: tree}
+		if err := src.validateReplace(layer.Replace); err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid config parameters:\n%v\n", err)
+			return err
+		}
+		sources = append(sources, src)
+
+		// The merge changes the sections it is given, so it has its own copy
+		tree = map[interface{}]interface{}{}
+		if err := yaml.Unmarshal(dat, &tree); err != nil {
+			return err
+		}
+		mergeTree(merged, tree, "", layer.Replace)
+	}
+
+	delete(merged, "replace")
+	dat, err := yaml.Marshal(merged)
 	if err != nil {
-		fmt.Printf("Error reading config parameters: %v\n", err)
+		return err
+	}
+	err = parse(path, dat, &Store)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error parsing config parameters:\n%v\n", err)
 		return err
 	}
 
-	err = yaml.Unmarshal(dat, &Store)
+	err = validate(sources, applyEnv(&Store), &Store)
 	if err != nil {
-		fmt.Printf("Error parsing config parameters: %v\n", err)
+		fmt.Fprintf(os.Stderr, "Invalid config parameters:\n%v\n", err)
 		return err
 	}
 
@@ -52,9 +247,62 @@ func Read(path string) error {
 	return nil
 }
 
+// Print writes the effective config parameters in yaml format
+func Print(w io.Writer) error {
+	dat, err := yaml.Marshal(&Store)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(dat)
+	return err
+}
+
 func setDefaults() {
 	if Store.Backup.Size <= 0 {
-		audit.Printf("Default the retained data size to `%d`\n", defaultBackupSize)
 		Store.Backup.Size = defaultBackupSize
 	}
+	if Store.Generations.Keep <= 0 {
+		Store.Generations.Keep = defaultGenerationsKeep
+	}
+	if Store.Compression.Workers <= 0 {
+		Store.Compression.Workers = runtime.NumCPU()
+	}
+
+	defaultString(&Store.Paths.RestoreMount, DefaultRestoreMount)
+	defaultString(&Store.Paths.WritableMount, DefaultWritableMount)
+	defaultString(&Store.Paths.TmpfsMount, DefaultTmpfsMount)
+	defaultString(&Store.Paths.SystemBootMount, DefaultSystemBootMount)
+	defaultString(&Store.Paths.RolesMount, DefaultRolesMount)
+	defaultString(&Store.Paths.WritableArchive, DefaultWritableArchive)
+	defaultString(&Store.Paths.SystemBootImage, DefaultSystemBootImage)
+	defaultString(&Store.Paths.Log, audit.DefaultLogFile)
+	defaultString(&Store.Paths.BootprintLog, DefaultLogFileBootprint)
+	defaultString(&Store.Paths.ResetLog, DefaultLogFileReset)
+	defaultString(&Store.Triggers.Cmdline, DefaultCmdlineTrigger)
+	defaultString(&Store.UBoot.Request, DefaultRequestVariable)
+	defaultString(&Store.UBoot.Status, DefaultStatusVariable)
+	defaultString(&Store.UBoot.Timestamp, DefaultTimeVariable)
+	defaultString(&Store.Grub.Request, DefaultRequestVariable)
+	defaultString(&Store.Grub.Status, DefaultStatusVariable)
+	defaultString(&Store.Grub.Timestamp, DefaultTimeVariable)
+	defaultString(&Store.PostReset.Action, DefaultPostResetAction)
+	defaultString(&Store.PostReset.OnFailure, DefaultPostResetAction)
+	defaultString(&Store.Layout, LayoutUC16)
+	if len(Store.Slots.Partitions) > 0 {
+		defaultString(&Store.Slots.Env, Store.Slots.Partitions[0].Name)
+		defaultString(&Store.Slots.Restore, SlotsAll)
+	}
+	if Store.Layout == LayoutUC20 {
+		defaultString(&Store.Roles.UbuntuSeed, RoleKeep)
+		defaultString(&Store.Roles.UbuntuBoot, RoleImage)
+		defaultString(&Store.Roles.UbuntuSave, RoleKeep)
+		defaultString(&Store.Roles.UbuntuData, RoleArchive)
+	}
+}
+
+// defaultString sets a string parameter, if it is not already set
+func defaultString(param *string, value string) {
+	if len(*param) == 0 {
+		*param = value
+	}
 }
@@ -5,10 +5,16 @@
 package config_test
 
 import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/CanonicalLtd/flashback/config"
 	check "gopkg.in/check.v1"
+	yaml "gopkg.in/yaml.v2"
 )
 
 type SuiteTest struct {
@@ -38,3 +44,173 @@ func (s *configSuite) TestRead(c *check.C) {
 		}
 	}
 }
+
+func (s *configSuite) TestReadPartitions(c *check.C) {
+	err := config.Read("../example.yaml")
+	c.Assert(err, check.IsNil)
+
+	c.Assert(config.Store.Partitions.SystemBoot.Label, check.Equals, "system-boot")
+	c.Assert(config.Store.Partitions.Restore.Label, check.Equals, "restore")
+	c.Assert(config.Store.Partitions.Writable.Label, check.Equals, "writable")
+	c.Assert(config.Store.Partitions.Writable.UUID, check.Equals, "")
+}
+
+func (s *configSuite) TestReadPathDefaults(c *check.C) {
+	path := filepath.Join(c.MkDir(), "config.yaml")
+	err := ioutil.WriteFile(path, []byte("paths:\n  restore-mount: /run/restore\n"), 0644)
+	c.Assert(err, check.IsNil)
+
+	err = config.Read(path)
+	c.Assert(err, check.IsNil)
+
+	c.Assert(config.Store.Paths.RestoreMount, check.Equals, "/run/restore")
+	c.Assert(config.Store.Paths.WritableMount, check.Equals, config.DefaultWritableMount)
+	c.Assert(config.Store.Paths.WritableArchive, check.Equals, config.DefaultWritableArchive)
+	c.Assert(config.Store.Paths.ResetLog, check.Equals, config.DefaultLogFileReset)
+}
+
+func (s *configSuite) TestPrint(c *check.C) {
+	// Nothing is written to stdout while the config is read
+	r, w, err := os.Pipe()
+	c.Assert(err, check.IsNil)
+	stdout := os.Stdout
+	os.Stdout = w
+	err = config.Read("../example.yaml")
+	os.Stdout = stdout
+	c.Assert(w.Close(), check.IsNil)
+	c.Assert(err, check.IsNil)
+	written, err := ioutil.ReadAll(r)
+	c.Assert(err, check.IsNil)
+	c.Assert(string(written), check.Equals, "")
+
+	// The effective config reads back as the same config
+	buf := bytes.Buffer{}
+	c.Assert(config.Print(&buf), check.IsNil)
+	printed := config.Config{}
+	c.Assert(yaml.UnmarshalStrict(buf.Bytes(), &printed), check.IsNil)
+	c.Assert(printed.Backup, check.DeepEquals, config.Store.Backup)
+	c.Assert(printed.Paths, check.DeepEquals, config.Store.Paths)
+	again, err := yaml.Marshal(&printed)
+	c.Assert(err, check.IsNil)
+	c.Assert(string(again), check.Equals, buf.String())
+}
+
+func (s *configSuite) TestValidate(c *check.C) {
+	tests := []struct {
+		config  string
+		problem string
+	}{
+		{"retain:\n  size: 8\n  data:\n    - /var/log\n", ""},
+		{"retain:\n  unknown: 1\n", ":2: field unknown not found"},
+		{"retain:\n  size: big\n", ":2: cannot unmarshal"},
+		{"retain:\n  size: -4\n", "config.yaml: retain.size: must not be negative"},
+		{"generations:\n  keep: -1\n", "config.yaml: generations.keep: must not be negative"},
+		{"compression:\n  workers: -2\n", "config.yaml: compression.workers: must not be negative"},
+		{"retain:\n  data:\n    - var/log\n", "config.yaml: retain.data: `var/log` is not an absolute path"},
+		{"retain:\n  data:\n    - /var/log\n    - /var/log\n", "config.yaml: retain.data: `/var/log` is a duplicate"},
+		{"retain:\n  data:\n    - /var/log\n    - /var/log/\n", "config.yaml: retain.data: `/var/log/` is a duplicate"},
+		{"retain:\n  data:\n    - /var/log/\n    - /var//log\n", "config.yaml: retain.data: `/var//log` is a duplicate"},
+		{"retain:\n  data:\n    - \"\"\n", "config.yaml: retain.data: empty path"},
+		{"retain:\n  data:\n    - /var/../../etc\n", "config.yaml: retain.data: `/var/../../etc` is outside system-data"},
+		{"retain:\n  snaps:\n    - name: nm\n      data:\n        - ../x\n", "config.yaml: retain.snaps.data: `../x` is outside"},
+		{"restore:\n  - label: custom1\n    file: custom1.img.gz\n    type: zip\n", "config.yaml: restore.type: `zip` must be one of"},
+		{"paths:\n  restore-mount: restore\n", "config.yaml: paths.restore-mount: `restore` is not an absolute path"},
+		{"post-reset:\n  action: explode\n", "config.yaml: post-reset.action: `explode` must be one of"},
+		{"layout: uc20\nroles:\n  ubuntu-save: archive\n", ""},
+		{"layout: uc18\n", "config.yaml: layout: `uc18` must be one of"},
+		{"layout: uc20\nroles:\n  ubuntu-boot: archive\n", "config.yaml: roles.ubuntu-boot: `archive` must be one of"},
+		{"layout: uc20\nroles:\n  ubuntu-data: keep\n", "config.yaml: roles.ubuntu-data: `keep` must be one of"},
+		{"roles:\n  ubuntu-save: image\n", "roles: only used with the `uc20` layout"},
+		{"uboot:\n  file: uboot.env\nslots:\n  partitions:\n    - name: a\n    - name: b\n  selector: boot_slot\n  restore: active\n", ""},
+		{"uboot:\n  file: uboot.env\nslots:\n  partitions:\n    - name: a\n    - name: a\n  selector: boot_slot\n", "config.yaml: slots.partitions.name: `a` is a duplicate"},
+		{"uboot:\n  file: uboot.env\nslots:\n  partitions:\n    - name: a\n  selector: boot_slot\n  env: c\n", "config.yaml: slots.env: `c` is not a slot"},
+		{"uboot:\n  file: uboot.env\nslots:\n  partitions:\n    - name: a\n  selector: boot_slot\n  restore: some\n", "config.yaml: slots.restore: `some` must be one of"},
+		{"slots:\n  partitions:\n    - name: a\n", "slots.selector: must be set"},
+		{"slots:\n  partitions:\n    - name: a\n  selector: boot_slot\n", "slots.selector: needs `uboot.file` or `grub.file`"},
+		{"slots:\n  selector: boot_slot\n", "slots: no slot partitions"},
+		{"metrics:\n  file: /var/lib/prometheus/node-exporter/flashback.prom\n", ""},
+		{"metrics:\n  file: flashback.prom\n", "config.yaml: metrics.file: `flashback.prom` is not an absolute path"},
+		{"metrics:\n  file: /var/../../flashback.prom\n", "config.yaml: metrics.file: `/var/../../flashback.prom` is outside system-data"},
+		{"uboot:\n  file: ../boot.env\ngrub:\n  file: ../boot.env\n", "config.yaml: grub.file: `../boot.env` must be a path within the partition"},
+		{"retain:\n  snaps:\n    - name: nm\n      data:\n        - ../x\n  data:\n    - ../x\n", "config.yaml: retain.data: `../x` is not an absolute path"},
+		{"retain:\n  data:\n  - var/log\n", "config.yaml: retain.data: `var/log` is not an absolute path"},
+	}
+
+	path := filepath.Join(c.MkDir(), "config.yaml")
+	for _, t := range tests {
+		err := ioutil.WriteFile(path, []byte(t.config), 0644)
+		c.Assert(err, check.IsNil)
+
+		err = config.Read(path)
+
+		if len(t.problem) == 0 {
+			c.Assert(err, check.IsNil, check.Commentf(t.config))
+			continue
+		}
+		c.Assert(err, check.NotNil, check.Commentf(t.config))
+		c.Assert(strings.Contains(err.Error(), t.problem), check.Equals, true, check.Commentf("%s: %v", t.config, err))
+	}
+}
+
+func (s *configSuite) TestReadDropIns(c *check.C) {
+	dir := c.MkDir()
+	path := filepath.Join(dir, "config.yaml")
+	files := map[string]string{
+		"config.yaml":           "retain:\n  size: 16\n  data:\n    - /var/log/a\n",
+		"config.d/10-log.yaml":  "retain:\n  data:\n    - /var/log/b\n",
+		"config.d/20-size.yaml": "retain:\n  size: 64\n  data:\n    - /var/log/c\n",
+		"config.d/README":       "not a config file",
+	}
+	for name, content := range files {
+		c.Assert(os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), 0755), check.IsNil)
+		c.Assert(ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644), check.IsNil)
+	}
+
+	// Lists are appended in lexical order
+	c.Assert(config.Read(path), check.IsNil)
+	c.Assert(config.Store.Backup.Size, check.Equals, 64)
+	c.Assert(config.Store.Backup.Data, check.DeepEquals, []string{"/var/log/a", "/var/log/b", "/var/log/c"})
+
+	// A drop-in can replace a list
+	replace := "replace:\n  - retain.data\nretain:\n  data:\n    - /var/log/d\n"
+	c.Assert(ioutil.WriteFile(filepath.Join(dir, "config.d/30-replace.yaml"), []byte(replace), 0644), check.IsNil)
+	c.Assert(config.Read(path), check.IsNil)
+	c.Assert(config.Store.Backup.Data, check.DeepEquals, []string{"/var/log/d"})
+
+	// Duplicates are found across the files
+	c.Assert(ioutil.WriteFile(filepath.Join(dir, "config.d/40-dup.yaml"), []byte("retain:\n  data:\n    - /var/log/d\n"), 0644), check.IsNil)
+	err := config.Read(path)
+	c.Assert(err, check.ErrorMatches, ".*40-dup.yaml: retain.data: `/var/log/d` is a duplicate")
+	c.Assert(os.Remove(filepath.Join(dir, "config.d/40-dup.yaml")), check.IsNil)
+
+	// Problems are found in the file that sets the value
+	c.Assert(ioutil.WriteFile(filepath.Join(dir, "config.d/45-action.yaml"), []byte("retain:\n  data:\n    - /var/log/e/\n    - /var/log/d/\npost-reset:\n  action: explode\n"), 0644), check.IsNil)
+	err = config.Read(path)
+	c.Assert(err, check.ErrorMatches, "(?s).*45-action.yaml: post-reset.action: `explode` must be one of.*")
+	c.Assert(err, check.ErrorMatches, "(?s).*45-action.yaml: retain.data: `/var/log/d/` is a duplicate.*")
+	c.Assert(os.Remove(filepath.Join(dir, "config.d/45-action.yaml")), check.IsNil)
+
+	// Only lists can be replaced
+	c.Assert(ioutil.WriteFile(filepath.Join(dir, "config.d/50-bad.yaml"), []byte("replace: [retain.size]\n"), 0644), check.IsNil)
+	c.Assert(config.Read(path), check.NotNil)
+}
+
+func (s *configSuite) TestReadEnvironment(c *check.C) {
+	os.Setenv("FLASHBACK_RETAIN_SIZE", "128")
+	os.Setenv("FLASHBACK_PATHS_RESTORE_MOUNT", "/run/restore")
+	os.Setenv("FLASHBACK_RETAIN_DATA", "/var/log/a, /var/log/b")
+	os.Setenv("FLASHBACK_VERIFY_READ_BACK", "true")
+	defer os.Unsetenv("FLASHBACK_RETAIN_SIZE")
+	defer os.Unsetenv("FLASHBACK_PATHS_RESTORE_MOUNT")
+	defer os.Unsetenv("FLASHBACK_RETAIN_DATA")
+	defer os.Unsetenv("FLASHBACK_VERIFY_READ_BACK")
+
+	c.Assert(config.Read("../example.yaml"), check.IsNil)
+	c.Assert(config.Store.Backup.Size, check.Equals, 128)
+	c.Assert(config.Store.Paths.RestoreMount, check.Equals, "/run/restore")
+	c.Assert(config.Store.Backup.Data, check.DeepEquals, []string{"/var/log/a", "/var/log/b"})
+	c.Assert(config.Store.Verify.ReadBack, check.Equals, true)
+
+	os.Setenv("FLASHBACK_RETAIN_SIZE", "lots")
+	c.Assert(config.Read("../example.yaml"), check.ErrorMatches, ".*FLASHBACK_RETAIN_SIZE: `lots` is not a number")
+}
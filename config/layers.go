@@ -0,0 +1,133 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+// Flashback
+// Copyright 2018 Canonical Ltd.  All rights reserved.
+
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	// DropInDir is the directory of drop-in files, next to the base config file
+	DropInDir = "config.d"
+	// EnvPrefix is the prefix of the environment variables that override the config
+	EnvPrefix = "FLASHBACK_"
+)
+
+// listFields are the lists that a drop-in file can replace instead of appending to
+var listFields = []string{"retain.data", "retain.snaps", "triggers.markers", "restore", "slots.partitions"}
+
+// configFiles returns the base config file followed by the drop-in files in lexical order
+func configFiles(path string) ([]string, error) {
+	files := []string{path}
+
+	dropIns := []string{}
+	for _, pattern := range []string{"*.yaml", "*.yml"} {
+		matches, err := filepath.Glob(filepath.Join(filepath.Dir(path), DropInDir, pattern))
+		if err != nil {
+			return nil, err
+		}
+		dropIns = append(dropIns, matches...)
+	}
+	sort.Strings(dropIns)
+
+	return append(files, dropIns...), nil
+}
+
+// mergeTree merges a config layer into the merged parameters. Sections are
+// merged, lists are appended unless they are replaced by the layer, and
+// other values are overridden
+func mergeTree(merged, layer map[interface{}]interface{}, prefix string, replace []string) {
+	for k, v := range layer {
+		field := fmt.Sprintf("%s%v", prefix, k)
+
+		switch value := v.(type) {
+		case nil:
+			// An empty value does not change the parameter
+			continue
+		case map[interface{}]interface{}:
+			if section, ok := merged[k].(map[interface{}]interface{}); ok {
+				mergeTree(section, value, field+".", replace)
+				continue
+			}
+		case []interface{}:
+			if list, ok := merged[k].([]interface{}); ok && !contains(replace, field) {
+				merged[k] = append(list, value...)
+				continue
+			}
+		}
+		merged[k] = v
+	}
+}
+
+// applyEnv overrides the config parameters from the environment. The names
+// of the variables are built from the fields e.g. FLASHBACK_RETAIN_SIZE or
+// FLASHBACK_PATHS_RESTORE_MOUNT. Lists of strings are separated by commas
+func applyEnv(c *Config) []problem {
+	return applyEnvFields(reflect.ValueOf(c).Elem(), EnvPrefix)
+}
+
+func applyEnvFields(v reflect.Value, prefix string) []problem {
+	problems := []problem{}
+
+	for i := 0; i < v.NumField(); i++ {
+		tag := strings.Split(v.Type().Field(i).Tag.Get("yaml"), ",")[0]
+		if len(tag) == 0 || tag == "-" {
+			continue
+		}
+		name := prefix + strings.ToUpper(strings.Replace(tag, "-", "_", -1))
+		field := v.Field(i)
+
+		if field.Kind() == reflect.Struct {
+			problems = append(problems, applyEnvFields(field, name+"_")...)
+			continue
+		}
+
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			continue
+		}
+
+		switch field.Kind() {
+		case reflect.String:
+			field.SetString(value)
+		case reflect.Int:
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				problems = append(problems, problem{message: fmt.Sprintf("%s: `%s` is not a number", name, value)})
+				continue
+			}
+			field.SetInt(int64(n))
+		case reflect.Bool:
+			b, err := strconv.ParseBool(value)
+			if err != nil {
+				problems = append(problems, problem{message: fmt.Sprintf("%s: `%s` is not true or false", name, value)})
+				continue
+			}
+			field.SetBool(b)
+		case reflect.Slice:
+			if field.Type().Elem().Kind() != reflect.String {
+				problems = append(problems, problem{message: fmt.Sprintf("%s: cannot be set from the environment", name)})
+				continue
+			}
+			list := []string{}
+			for _, item := range strings.Split(value, ",") {
+				if item = strings.TrimSpace(item); len(item) > 0 {
+					list = append(list, item)
+				}
+			}
+			field.Set(reflect.ValueOf(list))
+		default:
+			problems = append(problems, problem{message: fmt.Sprintf("%s: cannot be set from the environment", name)})
+		}
+	}
+
+	return problems
+}
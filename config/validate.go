@@ -0,0 +1,415 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+// Flashback
+// Copyright 2018 Canonical Ltd.  All rights reserved.
+
+package config
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// Values that are accepted in the config file
+var (
+	validActions     = []string{"reboot", "poweroff", "halt", "none"}
+	validRestoreType = []string{"img", "tar"}
+	validMarkerParts = []string{"system-boot", "restore", "ubuntu-seed", "ubuntu-boot", "ubuntu-save"}
+	validLayouts     = []string{LayoutUC16, LayoutUC20}
+	validRoles       = []string{RoleImage, RoleArchive, RoleKeep}
+	validDataRoles   = []string{RoleArchive}
+	validBootRoles   = []string{RoleImage, RoleKeep}
+	validSlotRestore = []string{SlotsAll, SlotsActive}
+	validSnapAreas   = []string{"current", "common"}
+	validSnapName    = regexp.MustCompile("^[a-z0-9][a-z0-9-]*$")
+)
+
+// ValidationError lists the problems found in a config file
+type ValidationError struct {
+	Path     string
+	Problems []string
+}
+
+// Error formats the problems, one per line
+func (e *ValidationError) Error() string {
+	return strings.Join(e.Problems, "\n")
+}
+
+// source is a config file and its parsed values, for finding the file
+// that sets a value with a problem
+type source struct {
+	path string
+	tree map[interface{}]interface{}
+}
+
+// validator checks the config parameters, finding the files with the problems
+type validator struct {
+	sources  []source
+	problems []problem
+}
+
+// problem is an error in one of the config files. The line is only known
+// for the errors from the YAML parser, and is zero otherwise
+type problem struct {
+	source  int
+	line    int
+	message string
+}
+
+// err returns the problems in the order of the files, or nil if there are none
+func (v *validator) err() error {
+	if len(v.problems) == 0 {
+		return nil
+	}
+
+	// Problems without a line number go last in each file
+	sort.SliceStable(v.problems, func(i, j int) bool {
+		a, b := v.problems[i], v.problems[j]
+		if a.source != b.source {
+			return a.source < b.source
+		}
+		return a.line > 0 && (b.line == 0 || a.line < b.line)
+	})
+
+	e := &ValidationError{Path: v.sources[0].path}
+	for _, p := range v.problems {
+		path := v.sources[p.source].path
+		if p.line > 0 {
+			e.Problems = append(e.Problems, fmt.Sprintf("%s:%d: %s", path, p.line, p.message))
+		} else {
+			e.Problems = append(e.Problems, fmt.Sprintf("%s: %s", path, p.message))
+		}
+	}
+	return e
+}
+
+// parse reads a config file into the config, rejecting unknown fields and
+// values of the wrong type
+func parse(path string, dat []byte, c *Config) error {
+	err := yaml.UnmarshalStrict(dat, c)
+	if err == nil {
+		return nil
+	}
+
+	v := &validator{sources: []source{{path: path}}}
+	if typeErr, ok := err.(*yaml.TypeError); ok {
+		for _, e := range typeErr.Errors {
+			v.problems = append(v.problems, yamlProblem(e))
+		}
+	} else {
+		v.problems = append(v.problems, yamlProblem(strings.TrimPrefix(err.Error(), "yaml: ")))
+	}
+	return v.err()
+}
+
+// yamlProblem splits the line number from a YAML error e.g. "line 3: field x not found"
+func yamlProblem(message string) problem {
+	var line int
+	if n, _ := fmt.Sscanf(message, "line %d:", &line); n == 1 {
+		return problem{line: line, message: strings.TrimSpace(strings.SplitN(message, ":", 2)[1])}
+	}
+	return problem{message: message}
+}
+
+// validateReplace checks that a config file only replaces lists
+func (src source) validateReplace(replace []string) error {
+	v := &validator{sources: []source{src}}
+	for _, r := range replace {
+		v.oneOf("replace", r, listFields)
+	}
+	return v.err()
+}
+
+// validate checks the values of the merged config parameters, finding the
+// config files with the problems
+func validate(sources []source, problems []problem, c *Config) error {
+	v := &validator{sources: sources, problems: problems}
+
+	v.partition("partitions.system-boot", c.Partitions.SystemBoot)
+	v.partition("partitions.restore", c.Partitions.Restore)
+	v.partition("partitions.writable", c.Partitions.Writable)
+	v.partition("partitions.ubuntu-seed", c.Partitions.UbuntuSeed)
+	v.partition("partitions.ubuntu-boot", c.Partitions.UbuntuBoot)
+	v.partition("partitions.ubuntu-save", c.Partitions.UbuntuSave)
+	v.partition("partitions.ubuntu-data", c.Partitions.UbuntuData)
+
+	v.oneOf("layout", c.Layout, validLayouts)
+	v.oneOf("roles.ubuntu-seed", c.Roles.UbuntuSeed, validRoles)
+	v.oneOf("roles.ubuntu-boot", c.Roles.UbuntuBoot, validBootRoles)
+	v.oneOf("roles.ubuntu-save", c.Roles.UbuntuSave, validRoles)
+	v.oneOf("roles.ubuntu-data", c.Roles.UbuntuData, validDataRoles)
+	if c.Layout != LayoutUC20 && c.Roles != (Config{}).Roles {
+		v.problem("roles", "", "roles: only used with the `uc20` layout")
+	}
+
+	v.slots(c)
+
+	v.absolute("paths.restore-mount", c.Paths.RestoreMount)
+	v.absolute("paths.writable-mount", c.Paths.WritableMount)
+	v.absolute("paths.tmpfs-mount", c.Paths.TmpfsMount)
+	v.absolute("paths.system-boot-mount", c.Paths.SystemBootMount)
+	v.absolute("paths.roles-mount", c.Paths.RolesMount)
+	v.absolute("paths.log", c.Paths.Log)
+	v.absolute("paths.bootprint-log", c.Paths.BootprintLog)
+	v.absolute("paths.reset-log", c.Paths.ResetLog)
+
+	for _, m := range c.Triggers.Markers {
+		v.oneOf("triggers.markers.partition", m.Partition, validMarkerParts)
+		v.relative("triggers.markers.file", m.File)
+	}
+
+	v.relative("uboot.file", c.UBoot.File)
+	v.relative("uboot.redundant", c.UBoot.Redundant)
+	v.relative("grub.file", c.Grub.File)
+
+	v.oneOf("post-reset.action", c.PostReset.Action, validActions)
+	v.oneOf("post-reset.on-failure", c.PostReset.OnFailure, validActions)
+	if c.PostReset.Delay < 0 {
+		v.problem("post-reset.delay", fmt.Sprint(c.PostReset.Delay), "post-reset.delay: must not be negative")
+	}
+
+	if c.Backup.Size < 0 {
+		v.problem("retain.size", fmt.Sprint(c.Backup.Size), "retain.size: must not be negative")
+	}
+	v.retainData(c.Backup.Data)
+	v.retainSnaps(c.Backup.Snaps)
+
+	if c.Generations.Keep < 0 {
+		v.problem("generations.keep", fmt.Sprint(c.Generations.Keep), "generations.keep: must not be negative")
+	}
+
+	if c.Compression.Workers < 0 {
+		v.problem("compression.workers", fmt.Sprint(c.Compression.Workers), "compression.workers: must not be negative")
+	}
+
+	if len(c.Metrics.File) > 0 {
+		switch {
+		case !filepath.IsAbs(c.Metrics.File):
+			v.problem("metrics.file", c.Metrics.File, fmt.Sprintf("metrics.file: `%s` is not an absolute path", c.Metrics.File))
+		case hasParent(c.Metrics.File):
+			v.problem("metrics.file", c.Metrics.File, fmt.Sprintf("metrics.file: `%s` is outside system-data", c.Metrics.File))
+		}
+	}
+
+	for _, r := range c.Restore {
+		v.required("restore.label", r.Label)
+		v.required("restore.file", r.File)
+		v.oneOf("restore.type", r.Type, validRestoreType)
+	}
+
+	return v.err()
+}
+
+func (v *validator) partition(field string, p Partition) {
+	if len(p.Device) > 0 && !filepath.IsAbs(p.Device) {
+		v.problem(field+".device", p.Device, fmt.Sprintf("%s.device: `%s` is not an absolute path", field, p.Device))
+	}
+}
+
+// slots checks the boot slots of an A/B system-boot. The slot selector is a
+// variable in the U-Boot or GRUB environment, which is on one of the slots
+func (v *validator) slots(c *Config) {
+	if len(c.Slots.Partitions) == 0 {
+		if len(c.Slots.Selector) > 0 || len(c.Slots.Env) > 0 || len(c.Slots.Restore) > 0 {
+			v.problem("slots", "", "slots: no slot partitions")
+		}
+		return
+	}
+	if c.Layout == LayoutUC20 {
+		v.problem("slots", "", "slots: only used with the `uc16` layout")
+	}
+
+	names := map[string]int{}
+	for _, s := range c.Slots.Partitions {
+		names[s.Name]++
+		switch {
+		case len(strings.TrimSpace(s.Name)) == 0:
+			v.problem("slots.partitions.name", s.Name, "slots.partitions.name: must be set")
+		case names[s.Name] > 1:
+			v.problemAt("slots.partitions.name", s.Name, names[s.Name], fmt.Sprintf("slots.partitions.name: `%s` is a duplicate", s.Name))
+		}
+		v.partition("slots.partitions", s.Partition)
+	}
+
+	v.required("slots.selector", c.Slots.Selector)
+	if len(c.Slots.Env) > 0 && names[c.Slots.Env] == 0 {
+		v.problem("slots.env", c.Slots.Env, fmt.Sprintf("slots.env: `%s` is not a slot", c.Slots.Env))
+	}
+	if len(c.UBoot.File) == 0 && len(c.Grub.File) == 0 {
+		v.problems = append(v.problems, problem{message: "slots.selector: needs `uboot.file` or `grub.file`"})
+	}
+	v.oneOf("slots.restore", c.Slots.Restore, validSlotRestore)
+}
+
+// retainData checks the paths to keep, which are absolute paths in system-data.
+// Paths that are the same when cleaned e.g. /var/log and /var/log/ are duplicates
+func (v *validator) retainData(data []string) {
+	entries := map[string]int{}
+	seen := map[string]int{}
+	for _, d := range data {
+		entries[d]++
+		seen[filepath.Clean(d)]++
+		switch {
+		case len(strings.TrimSpace(d)) == 0:
+			v.problemAt("retain.data", d, entries[d], "retain.data: empty path")
+		case !filepath.IsAbs(d):
+			v.problem("retain.data", d, fmt.Sprintf("retain.data: `%s` is not an absolute path", d))
+		case hasParent(d):
+			v.problem("retain.data", d, fmt.Sprintf("retain.data: `%s` is outside system-data", d))
+		case filepath.Clean(d) == "/":
+			v.problem("retain.data", d, "retain.data: `/` keeps all of system-data")
+		case seen[filepath.Clean(d)] > 1:
+			v.problemAt("retain.data", d, entries[d], fmt.Sprintf("retain.data: `%s` is a duplicate", d))
+		}
+	}
+}
+
+// retainSnaps checks the snap names and their data areas
+func (v *validator) retainSnaps(snaps []Snap) {
+	names := map[string]int{}
+	for _, snap := range snaps {
+		names[snap.Name]++
+		if !validSnapName.MatchString(snap.Name) {
+			v.problem("retain.snaps.name", snap.Name, fmt.Sprintf("retain.snaps.name: `%s` is not a valid snap name", snap.Name))
+			continue
+		}
+		if names[snap.Name] > 1 {
+			v.problemAt("retain.snaps.name", snap.Name, names[snap.Name], fmt.Sprintf("retain.snaps.name: `%s` is a duplicate", snap.Name))
+		}
+
+		for _, area := range snap.Data {
+			first := strings.SplitN(area, "/", 2)[0]
+			switch {
+			case filepath.IsAbs(area):
+				v.problem("retain.snaps.data", area, fmt.Sprintf("retain.snaps.data: `%s` must be relative to the snap data", area))
+			case hasParent(area):
+				v.problem("retain.snaps.data", area, fmt.Sprintf("retain.snaps.data: `%s` is outside the snap data", area))
+			case !contains(validSnapAreas, first):
+				v.problem("retain.snaps.data", area, fmt.Sprintf("retain.snaps.data: `%s` must be in one of: %s", area, strings.Join(validSnapAreas, ", ")))
+			}
+		}
+	}
+}
+
+func (v *validator) absolute(field, value string) {
+	if len(value) > 0 && !filepath.IsAbs(value) {
+		v.problem(field, value, fmt.Sprintf("%s: `%s` is not an absolute path", field, value))
+	}
+}
+
+func (v *validator) relative(field, value string) {
+	if len(value) == 0 {
+		return
+	}
+	if filepath.IsAbs(value) || hasParent(value) {
+		v.problem(field, value, fmt.Sprintf("%s: `%s` must be a path within the partition", field, value))
+	}
+}
+
+func (v *validator) required(field, value string) {
+	if len(strings.TrimSpace(value)) == 0 {
+		v.problems = append(v.problems, problem{message: fmt.Sprintf("%s: must be set", field)})
+	}
+}
+
+func (v *validator) oneOf(field, value string, valid []string) {
+	if len(value) > 0 && !contains(valid, value) {
+		v.problem(field, value, fmt.Sprintf("%s: `%s` must be one of: %s", field, value, strings.Join(valid, ", ")))
+	}
+}
+
+// problem records a problem in the first file that sets the field to the value
+func (v *validator) problem(field, value, message string) {
+	v.problemAt(field, value, 1, message)
+}
+
+// problemAt records a problem in the file that sets the nth entry of the
+// field with the value
+func (v *validator) problemAt(field, value string, n int, message string) {
+	v.problems = append(v.problems, problem{source: v.findSource(field, value, n), message: message})
+}
+
+// findSource finds the file of the nth entry of a field e.g. slots.env, or
+// of a list field e.g. retain.data, with the value, in the order that the
+// files are merged. An empty value also matches a section e.g. roles. The
+// first file is used when the value is not in any of them e.g. it is set
+// from the environment
+func (v *validator) findSource(field, value string, n int) int {
+	for s, src := range v.sources {
+		for _, entry := range fieldEntries(src.tree, strings.Split(field, ".")) {
+			if matchEntry(entry, value) {
+				n--
+				if n == 0 {
+					return s
+				}
+			}
+		}
+	}
+	return 0
+}
+
+// fieldEntries returns the values of a field in a parsed file, following
+// the lists of sections e.g. restore.type is in each entry of restore
+func fieldEntries(node interface{}, keys []string) []interface{} {
+	switch n := node.(type) {
+	case []interface{}:
+		entries := []interface{}{}
+		for _, item := range n {
+			if len(keys) == 0 {
+				entries = append(entries, item)
+			} else {
+				entries = append(entries, fieldEntries(item, keys)...)
+			}
+		}
+		return entries
+	case map[interface{}]interface{}:
+		if len(keys) == 0 {
+			return []interface{}{n}
+		}
+		value, ok := n[keys[0]]
+		if !ok {
+			return nil
+		}
+		return fieldEntries(value, keys[1:])
+	default:
+		if len(keys) > 0 {
+			return nil
+		}
+		return []interface{}{n}
+	}
+}
+
+// matchEntry checks whether a parsed value is the value of a field
+func matchEntry(entry interface{}, value string) bool {
+	switch e := entry.(type) {
+	case nil:
+		return len(value) == 0
+	case map[interface{}]interface{}:
+		return len(value) == 0
+	default:
+		return fmt.Sprint(e) == value
+	}
+}
+
+// hasParent checks whether a path has a `..` element
+func hasParent(path string) bool {
+	for _, p := range strings.Split(path, "/") {
+		if p == ".." {
+			return true
+		}
+	}
+	return false
+}
+
+func contains(list []string, value string) bool {
+	for _, l := range list {
+		if l == value {
+			return true
+		}
+	}
+	return false
+}
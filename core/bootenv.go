@@ -0,0 +1,79 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+// Flashback
+// Copyright 2018 Canonical Ltd.  All rights reserved.
+
+package core
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/CanonicalLtd/flashback/bootenv"
+	"github.com/CanonicalLtd/flashback/config"
+)
+
+// EnvReader reads a boot environment from the mounted boot partition
+type EnvReader func() (bootenv.Env, error)
+
+// UBootEnv reads the U-Boot environment, and its redundant copy if one is set
+func UBootEnv(file, redundant string) EnvReader {
+	return func() (bootenv.Env, error) {
+		path := ""
+		if len(redundant) > 0 {
+			path = filepath.Join(SystemBootPath, redundant)
+		}
+		env, err := bootenv.ReadUBoot(filepath.Join(SystemBootPath, file), path)
+		if err != nil {
+			return nil, err
+		}
+		return env, nil
+	}
+}
+
+// GrubEnv reads the GRUB environment block
+func GrubEnv(file string) EnvReader {
+	return func() (bootenv.Env, error) {
+		env, err := bootenv.ReadGrub(filepath.Join(SystemBootPath, file))
+		if err != nil {
+			return nil, err
+		}
+		return env, nil
+	}
+}
+
+// ConfiguredEnv reads the U-Boot environment, or the GRUB environment block,
+// whichever is set in the config
+func ConfiguredEnv() (bootenv.Env, error) {
+	switch {
+	case len(config.Store.UBoot.File) > 0:
+		return UBootEnv(config.Store.UBoot.File, config.Store.UBoot.Redundant)()
+	case len(config.Store.Grub.File) > 0:
+		return GrubEnv(config.Store.Grub.File)()
+	}
+	return nil, fmt.Errorf("no U-Boot or GRUB environment is configured")
+}
+
+// WithBootEnv mounts the boot partition and reads the environment. The
+// environment is saved after the changes, if requested
+func WithBootEnv(write bool, read EnvReader, change func(env bootenv.Env)) error {
+	mount := Mount
+	if !write {
+		mount = MountReadOnly
+	}
+	if err := mount(PartitionTable.BootPartition(), SystemBootPath); err != nil {
+		return err
+	}
+	defer Unmount(SystemBootPath)
+
+	env, err := read()
+	if err != nil {
+		return err
+	}
+
+	change(env)
+
+	if !write {
+		return nil
+	}
+	return env.Write()
+}
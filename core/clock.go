@@ -0,0 +1,66 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+// Flashback
+// Copyright 2018 Canonical Ltd.  All rights reserved.
+
+package core
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/CanonicalLtd/flashback/audit"
+	"github.com/CanonicalLtd/flashback/manifest"
+)
+
+// AdvanceClock sets the system clock to a time, if the clock is behind it.
+// Devices without an RTC start in 1970, which breaks certificate validation
+func AdvanceClock(t time.Time) error {
+	now := time.Now()
+	if !now.Before(t) {
+		return nil
+	}
+
+	audit.Printf("Set the clock from %s to %s\n", now.UTC().Format(time.RFC3339), t.UTC().Format(time.RFC3339))
+	tv := syscall.NsecToTimeval(t.UnixNano())
+	return syscall.Settimeofday(&tv)
+}
+
+// AdvanceClockToImage advances the clock to the creation time of the recovery
+// image, so restored files and certificates are not in the future. The
+// restore partition must be mounted
+func AdvanceClockToImage() error {
+	created, err := manifest.ImageTime(ManifestFile, BackupImageWritable)
+	if err != nil {
+		return fmt.Errorf("cannot find the creation time of the recovery image: %v", err)
+	}
+	return AdvanceClock(created)
+}
+
+// AdvanceClockFromRestore mounts the restore partition read-only to advance
+// the clock to the creation time of the recovery image
+func AdvanceClockFromRestore() error {
+	if err := MountReadOnly(PartitionTable.Restore, RestorePath); err != nil {
+		return err
+	}
+	defer Unmount(RestorePath)
+
+	return AdvanceClockToImage()
+}
+
+// NewestModTime finds the latest modification time of the files in a directory
+func NewestModTime(dir string) (time.Time, error) {
+	newest := time.Time{}
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.ModTime().After(newest) {
+			newest = info.ModTime()
+		}
+		return nil
+	})
+	return newest, err
+}
@@ -0,0 +1,34 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+// Flashback
+// Copyright 2018 Canonical Ltd.  All rights reserved.
+
+package core_test
+
+import (
+	"path/filepath"
+	"time"
+
+	"github.com/CanonicalLtd/flashback/core"
+	"github.com/CanonicalLtd/flashback/manifest"
+	check "gopkg.in/check.v1"
+)
+
+func (s *coreSuite) TestAdvanceClockToImage(c *check.C) {
+	dir := c.MkDir()
+	manifestFile, archive := core.ManifestFile, core.BackupImageWritable
+	core.ManifestFile = filepath.Join(dir, core.ManifestFileName)
+	core.BackupImageWritable = filepath.Join(dir, "writable.tar.gz")
+	defer func() {
+		core.ManifestFile, core.BackupImageWritable = manifestFile, archive
+	}()
+
+	// Neither the manifest nor the archive
+	c.Assert(core.AdvanceClockToImage(), check.ErrorMatches, "cannot find the creation time of the recovery image: .*")
+
+	// The clock is not moved back to an older recovery image
+	before := time.Now()
+	m := manifest.New(time.Date(2018, 6, 1, 12, 0, 0, 0, time.UTC))
+	c.Assert(m.Write(core.ManifestFile), check.IsNil)
+	c.Assert(core.AdvanceClockToImage(), check.IsNil)
+	c.Assert(time.Now().Before(before), check.Equals, false)
+}
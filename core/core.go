@@ -6,13 +6,19 @@ package core
 
 import (
 	"github.com/CanonicalLtd/flashback/audit"
+	"github.com/CanonicalLtd/flashback/config"
 )
 
-// Partition identifies the path to the partitions
+// Partition identifies the path to the partitions. In the Ubuntu Core 20
+// layout, system-boot is ubuntu-boot if it is imaged, and writable is
+// ubuntu-data. The roles are the partitions of the Ubuntu Core 20 layout.
+// With A/B boot slots, the slots take the place of system-boot
 type Partition struct {
 	SystemBoot string
 	Restore    string
 	Writable   string
+	Roles      []Role
+	Slots      []Slot
 }
 
 // PartitionTable identifies the path to the partitions
@@ -20,36 +26,51 @@ var PartitionTable Partition
 
 // FindPartitions locates the three main partitions
 func FindPartitions() error {
+	if config.Store.Layout == config.LayoutUC20 {
+		return findUC20Partitions()
+	}
+
 	// Find "writable" partition and matching disk device
-	audit.Printf("Find the writable partition: %s", PartitionWritable)
-	writable, err := FindFS(PartitionWritable)
+	writable, err := findPartition(PartitionWritable, config.Store.Partitions.Writable)
 	if err != nil {
-		audit.Printf("Cannot find the writable partition: `%s` : %v\n", PartitionWritable, err)
-		return err
+		return NewError(FailurePartitionMissing, err)
 	}
-	audit.Println("Found writable partition at", writable)
 
 	// Find "restore" partition and matching disk device
-	audit.Printf("Find the restore partition: %s", PartitionRestore)
-	restore, err := FindFS(PartitionRestore)
+	restore, err := findPartition(PartitionRestore, config.Store.Partitions.Restore)
 	if err != nil {
-		audit.Printf("Cannot find the restore partition: `%s` : %v\n", PartitionRestore, err)
-		return err
+		return NewError(FailureRestoreMissing, err)
 	}
-	audit.Println("Found restore partition at", restore)
 
-	// Find "system-boot" partition and matching disk device
-	audit.Printf("Find the system-boot partition: %s", PartitionSystemBoot)
-	systemboot, err := FindFS(PartitionSystemBoot)
+	// Find "system-boot" partition and matching disk device, or the boot slots
+	table := Partition{Restore: restore, Writable: writable}
+	if len(config.Store.Slots.Partitions) > 0 {
+		table.Slots, err = findSlots()
+	} else {
+		table.SystemBoot, err = findPartition(PartitionSystemBoot, config.Store.Partitions.SystemBoot)
+	}
 	if err != nil {
-		audit.Printf("Cannot find the system-boot partition: `%s` : %v\n", PartitionSystemBoot, err)
-		return err
+		return NewError(FailurePartitionMissing, err)
 	}
-	audit.Println("Found system-boot partition at", systemboot)
 
 	// Save the partition device paths
-	PartitionTable.Restore = restore
-	PartitionTable.SystemBoot = systemboot
-	PartitionTable.Writable = writable
+	PartitionTable = table
 	return nil
 }
+
+// findPartition locates a partition using the configured identifiers,
+// defaulting to the partition label that matches the role
+func findPartition(role string, p config.Partition) (string, error) {
+	if p == (config.Partition{}) {
+		p.Label = role
+	}
+
+	audit.Printf("Find the %s partition: %s", role, describePartition(p))
+	device, err := FindPartition(p)
+	if err != nil {
+		audit.Printf("Cannot find the %s partition: `%s` : %v\n", role, describePartition(p), err)
+		return "", err
+	}
+	audit.Printf("Found %s partition at %s\n", role, device)
+	return device, nil
+}
@@ -0,0 +1,242 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+// Flashback
+// Copyright 2018 Canonical Ltd.  All rights reserved.
+
+package core
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DeletedList is the entry of a delta archive that lists the paths deleted
+// since the full archive, one per line. It comes first in the archive
+const DeletedList = ".deleted"
+
+// DeltaArchive is the path of the delta archive of a generation on the
+// restore partition, next to the full writable archive
+func DeltaArchive(generation int) string {
+	base := strings.TrimSuffix(BackupImageWritable, ".tar.gz")
+	return fmt.Sprintf("%s.delta-%d.tar.gz", base, generation)
+}
+
+// ArchiveIndex reads the headers of the entries of a gzipped tar file
+func ArchiveIndex(path string) (map[string]*tar.Header, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	gr, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, fmt.Errorf("`%s`: %v", path, err)
+	}
+	defer gr.Close()
+
+	index := map[string]*tar.Header{}
+	tr := tar.NewReader(gr)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return index, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("`%s`: %v", path, err)
+		}
+		index[filepath.Clean(header.Name)] = header
+	}
+}
+
+// TarDelta creates a tarball of the files in a directory structure that were
+// added or changed since the base archive, and the list of the files that
+// were deleted. Files are compared by type, mode, size, modification time and
+// link target, and regular files by the checksums of the base archive, so a
+// change that keeps the size and the modification time is found. Regular
+// files without a checksum in the base are compared by their metadata only.
+// The checksums of the changed files are written to a sums file if one is
+// given. Returns the number of changed and deleted entries
+func TarDelta(source string, base map[string]*tar.Header, baseSums map[string]string, tarball *tar.Writer, sums io.Writer) (int, int, error) {
+	baseDir := filepath.Base(source)
+
+	changed := []*tar.Header{}
+	paths := map[string]string{}
+	seen := map[string]bool{}
+
+	err := filepath.Walk(source,
+		func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			header, err := fileHeader(path, info)
+			if err != nil {
+				return err
+			}
+			header.Name = filepath.Join(baseDir, strings.TrimPrefix(path, source))
+
+			name := filepath.Clean(header.Name)
+			seen[name] = true
+			if old, ok := base[name]; ok && sameEntry(old, header) {
+				same, err := sameContent(path, header, baseSums[name])
+				if err != nil || same {
+					return err
+				}
+			}
+
+			changed = append(changed, header)
+			paths[header.Name] = path
+			return nil
+		})
+	if err != nil {
+		return 0, 0, err
+	}
+
+	deleted := []string{}
+	for name := range base {
+		if !seen[name] {
+			deleted = append(deleted, name)
+		}
+	}
+	sort.Strings(deleted)
+
+	// The deleted list goes first, so the deletions can be applied before the changes
+	if err := writeDeletedList(tarball, deleted); err != nil {
+		return 0, 0, err
+	}
+
+	for _, header := range changed {
+		if err := tarball.WriteHeader(header); err != nil {
+			return 0, 0, err
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		if err := copyFileTo(tarball, paths[header.Name], header.Name, sums); err != nil {
+			return 0, 0, err
+		}
+	}
+
+	return len(changed), len(deleted), nil
+}
+
+// ArchiveDeltaLists reads the paths of the changed entries of any type, and
+// the list of deleted paths, from a delta archive
+func ArchiveDeltaLists(path string) ([]string, []string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+
+	gr, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer gr.Close()
+
+	tr := tar.NewReader(gr)
+	header, err := tr.Next()
+	if err != nil {
+		return nil, nil, err
+	}
+	if header.Name != DeletedList {
+		return nil, nil, fmt.Errorf("`%s` is not a delta archive", path)
+	}
+	deleted, err := ReadDeletedList(tr)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	changed := []string{}
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		changed = append(changed, filepath.Clean(header.Name))
+	}
+	return changed, deleted, nil
+}
+
+// ReadDeletedList parses the list of deleted paths of a delta archive
+func ReadDeletedList(r io.Reader) ([]string, error) {
+	deleted := []string{}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		if line := scanner.Text(); len(line) > 0 {
+			deleted = append(deleted, line)
+		}
+	}
+	return deleted, scanner.Err()
+}
+
+func writeDeletedList(tarball *tar.Writer, deleted []string) error {
+	buf := bytes.Buffer{}
+	for _, name := range deleted {
+		buf.WriteString(name)
+		buf.WriteByte('\n')
+	}
+
+	header := &tar.Header{Name: DeletedList, Mode: 0644, Size: int64(buf.Len()), Typeflag: tar.TypeReg}
+	if err := tarball.WriteHeader(header); err != nil {
+		return err
+	}
+	_, err := tarball.Write(buf.Bytes())
+	return err
+}
+
+// sameEntry checks whether an entry is unchanged. Tar headers round the
+// modification time to the second
+func sameEntry(old, header *tar.Header) bool {
+	return old.Typeflag == header.Typeflag &&
+		old.Mode == header.Mode &&
+		old.Size == header.Size &&
+		old.Linkname == header.Linkname &&
+		old.ModTime.Round(time.Second).Equal(header.ModTime.Round(time.Second))
+}
+
+// sameContent checks whether a regular file still has the checksum it had in
+// the base archive. Other entries, and files without a checksum, are the same
+func sameContent(path string, header *tar.Header, sum string) (bool, error) {
+	if header.Typeflag != tar.TypeReg || len(sum) == 0 {
+		return true, nil
+	}
+	actual, err := HashFile(path)
+	if err != nil {
+		return false, err
+	}
+	return actual == sum, nil
+}
+
+// copyFileTo copies a file to the archive, adding its checksum to the sums file if one is given
+func copyFileTo(w io.Writer, path, name string, sums io.Writer) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if sums == nil {
+		_, err = io.Copy(w, file)
+		return err
+	}
+
+	h := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(w, h), file); err != nil {
+		return err
+	}
+	return writeSum(sums, h.Sum(nil), name)
+}
@@ -0,0 +1,149 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+// Flashback
+// Copyright 2018 Canonical Ltd.  All rights reserved.
+
+package core_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/CanonicalLtd/flashback/core"
+	check "gopkg.in/check.v1"
+)
+
+// writeTarGz archives a directory to a gzipped tar file, and returns the
+// checksums of its files
+func writeTarGz(c *check.C, source, path string) map[string]string {
+	f, err := os.Create(path)
+	c.Assert(err, check.IsNil)
+	defer f.Close()
+
+	gw := gzip.NewWriter(f)
+	tw := tar.NewWriter(gw)
+	sums := bytes.Buffer{}
+	c.Assert(core.TarSums(source, tw, &sums), check.IsNil)
+	c.Assert(tw.Close(), check.IsNil)
+	c.Assert(gw.Close(), check.IsNil)
+
+	parsed, err := core.ReadSums(&sums)
+	c.Assert(err, check.IsNil)
+	return parsed
+}
+
+func (s *coreSuite) TestTarDelta(c *check.C) {
+	dir := c.MkDir()
+	source := filepath.Join(dir, "system-data")
+	past := time.Now().Add(-time.Hour)
+	for _, name := range []string{"same", "changed", "deleted", "rewritten"} {
+		path := filepath.Join(source, "etc", name)
+		c.Assert(os.MkdirAll(filepath.Dir(path), 0755), check.IsNil)
+		c.Assert(ioutil.WriteFile(path, []byte(name), 0644), check.IsNil)
+		c.Assert(os.Chtimes(path, past, past), check.IsNil)
+	}
+	for _, path := range []string{filepath.Join(source, "etc"), source} {
+		c.Assert(os.Chtimes(path, past, past), check.IsNil)
+	}
+
+	archive := filepath.Join(dir, "writable.tar.gz")
+	baseSums := writeTarGz(c, source, archive)
+	base, err := core.ArchiveIndex(archive)
+	c.Assert(err, check.IsNil)
+	c.Assert(base, check.HasLen, 6)
+
+	// Change the files after the full archive. One keeps its size and
+	// modification time, so only the checksum shows the change
+	c.Assert(ioutil.WriteFile(filepath.Join(source, "etc", "changed"), []byte("new content"), 0644), check.IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(source, "etc", "added"), []byte("added"), 0644), check.IsNil)
+	c.Assert(os.Remove(filepath.Join(source, "etc", "deleted")), check.IsNil)
+	rewritten := filepath.Join(source, "etc", "rewritten")
+	c.Assert(ioutil.WriteFile(rewritten, []byte("REWRITTEN"), 0644), check.IsNil)
+	c.Assert(os.Chtimes(rewritten, past, past), check.IsNil)
+
+	buf := bytes.Buffer{}
+	tw := tar.NewWriter(&buf)
+	changed, deleted, err := core.TarDelta(source, base, baseSums, tw, nil)
+	c.Assert(err, check.IsNil)
+	c.Assert(tw.Close(), check.IsNil)
+	c.Assert(deleted, check.Equals, 1)
+
+	// The deleted list comes first, then the changed entries
+	names := []string{}
+	tr := tar.NewReader(&buf)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		c.Assert(err, check.IsNil)
+		names = append(names, header.Name)
+
+		if header.Name == core.DeletedList {
+			list, err := core.ReadDeletedList(tr)
+			c.Assert(err, check.IsNil)
+			c.Assert(list, check.DeepEquals, []string{"system-data/etc/deleted"})
+		}
+	}
+	c.Assert(names[0], check.Equals, core.DeletedList)
+	c.Assert(names[1:], check.HasLen, changed)
+	c.Assert(names, check.DeepEquals, []string{core.DeletedList, "system-data/etc", "system-data/etc/added", "system-data/etc/changed", "system-data/etc/rewritten"})
+
+	// Without the checksums of the base, files are compared by their metadata
+	tw = tar.NewWriter(&bytes.Buffer{})
+	changed, _, err = core.TarDelta(source, base, nil, tw, nil)
+	c.Assert(err, check.IsNil)
+	c.Assert(changed, check.Equals, 3)
+}
+
+func (s *coreSuite) TestArchiveDeltaLists(c *check.C) {
+	dir := c.MkDir()
+	source := filepath.Join(dir, "system-data")
+	past := time.Now().Add(-time.Hour)
+	for _, name := range []string{"hostname", "localtime", "deleted"} {
+		path := filepath.Join(source, "etc", name)
+		c.Assert(os.MkdirAll(filepath.Dir(path), 0755), check.IsNil)
+		c.Assert(ioutil.WriteFile(path, []byte(name), 0644), check.IsNil)
+		c.Assert(os.Chtimes(path, past, past), check.IsNil)
+	}
+	for _, path := range []string{filepath.Join(source, "etc"), source} {
+		c.Assert(os.Chtimes(path, past, past), check.IsNil)
+	}
+
+	archive := filepath.Join(dir, "writable.tar.gz")
+	writeTarGz(c, source, archive)
+	base, err := core.ArchiveIndex(archive)
+	c.Assert(err, check.IsNil)
+
+	// A regular file that became a link has no checksum in the delta
+	localtime := filepath.Join(source, "etc", "localtime")
+	c.Assert(os.Remove(localtime), check.IsNil)
+	c.Assert(os.Symlink("/usr/share/zoneinfo/UTC", localtime), check.IsNil)
+	c.Assert(os.Remove(filepath.Join(source, "etc", "deleted")), check.IsNil)
+
+	delta := filepath.Join(dir, "writable-delta.tar.gz")
+	f, err := os.Create(delta)
+	c.Assert(err, check.IsNil)
+	gw := gzip.NewWriter(f)
+	tw := tar.NewWriter(gw)
+	sums := bytes.Buffer{}
+	_, _, err = core.TarDelta(source, base, nil, tw, &sums)
+	c.Assert(err, check.IsNil)
+	c.Assert(tw.Close(), check.IsNil)
+	c.Assert(gw.Close(), check.IsNil)
+	c.Assert(f.Close(), check.IsNil)
+	c.Assert(sums.Len(), check.Equals, 0)
+
+	changed, deleted, err := core.ArchiveDeltaLists(delta)
+	c.Assert(err, check.IsNil)
+	c.Assert(changed, check.DeepEquals, []string{"system-data/etc", "system-data/etc/localtime"})
+	c.Assert(deleted, check.DeepEquals, []string{"system-data/etc/deleted"})
+
+	_, _, err = core.ArchiveDeltaLists(archive)
+	c.Assert(err, check.ErrorMatches, ".* is not a delta archive")
+}
@@ -0,0 +1,189 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+// Flashback
+// Copyright 2018 Canonical Ltd.  All rights reserved.
+
+package core
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/CanonicalLtd/flashback/audit"
+)
+
+// DropCachesPath is the file that drops the page cache, so data is read back from the disk
+var DropCachesPath = "/proc/sys/vm/drop_caches"
+
+// Digest is the size and SHA-256 checksum of the content of an image
+type Digest struct {
+	Size   int64  `yaml:"size"`
+	SHA256 string `yaml:"sha256"`
+}
+
+// SumsFile is the path of the file checksums of an archive, in the format of sha256sum
+func SumsFile(archive string) string {
+	return archive + ".sha256"
+}
+
+// DropCaches flushes the writes to the disk and drops the page cache
+func DropCaches() error {
+	syscall.Sync()
+	return ioutil.WriteFile(DropCachesPath, []byte("3\n"), 0644)
+}
+
+// HashDevice reads back the start of a device and returns its SHA-256
+// checksum. The buffer cache of the device is flushed first
+func HashDevice(device string, size int64) (string, error) {
+	if err := FlushDevice(device); err != nil {
+		return "", err
+	}
+
+	f, err := os.Open(device)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.CopyN(h, f, size); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// HashFile returns the SHA-256 checksum of a file
+func HashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// writeSum adds the checksum of a file to a sums file. As with sha256sum, a
+// name with a backslash or a newline is escaped, and the line starts with a
+// backslash
+func writeSum(sums io.Writer, sum []byte, name string) error {
+	prefix := ""
+	if strings.ContainsAny(name, "\\\n") {
+		prefix = "\\"
+		name = sumsEscaper.Replace(name)
+	}
+	_, err := fmt.Fprintf(sums, "%s%x  %s\n", prefix, sum, name)
+	return err
+}
+
+var (
+	sumsEscaper   = strings.NewReplacer("\\", "\\\\", "\n", "\\n")
+	sumsUnescaper = strings.NewReplacer("\\\\", "\\", "\\n", "\n")
+)
+
+// ReadSums parses a sums file into a map of the names to the checksums
+func ReadSums(r io.Reader) (map[string]string, error) {
+	sums := map[string]string{}
+	err := scanSums(r, func(sum, name string) error {
+		sums[name] = sum
+		return nil
+	})
+	return sums, err
+}
+
+// VerifySums checks the files in a directory against a sums file. The sums
+// file is streamed, and the files for which skip is true are not checked.
+// Returns the number of files that were checked
+func VerifySums(root string, sums io.Reader, skip func(name string) bool) (int, error) {
+	count := 0
+	mismatches := 0
+	err := scanSums(sums, func(sum, name string) error {
+		if skip != nil && skip(name) {
+			return nil
+		}
+		count++
+		if err := verifySum(root, sum, name); err != nil {
+			audit.Println("Read-back mismatch:", err)
+			mismatches++
+		}
+		return nil
+	})
+	if err != nil {
+		return count, err
+	}
+	if mismatches > 0 {
+		return count, fmt.Errorf("%d of %d files do not match the recovery image", mismatches, count)
+	}
+	return count, nil
+}
+
+// VerifySumsMap checks the files in a directory against the checksums in a map
+func VerifySumsMap(root string, sums map[string]string) (int, error) {
+	mismatches := 0
+	for name, sum := range sums {
+		if err := verifySum(root, sum, name); err != nil {
+			audit.Println("Read-back mismatch:", err)
+			mismatches++
+		}
+	}
+	if mismatches > 0 {
+		return len(sums), fmt.Errorf("%d of %d files do not match the recovery image", mismatches, len(sums))
+	}
+	return len(sums), nil
+}
+
+// verifySum checks a regular file against its checksum. The file is not
+// followed if it is now a link
+func verifySum(root, sum, name string) error {
+	path := filepath.Join(root, name)
+	info, err := os.Lstat(path)
+	if err != nil {
+		return err
+	}
+	if !info.Mode().IsRegular() {
+		return fmt.Errorf("`%s`: is not a regular file", name)
+	}
+	actual, err := HashFile(path)
+	if err != nil {
+		return err
+	}
+	if actual != sum {
+		return fmt.Errorf("`%s`: checksum %s, expected %s", name, actual, sum)
+	}
+	return nil
+}
+
+// scanSums parses a sums file, unescaping the names of the lines that start
+// with a backslash
+func scanSums(r io.Reader, handle func(sum, name string) error) error {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if len(line) == 0 {
+			continue
+		}
+		escaped := strings.HasPrefix(line, "\\")
+		fields := strings.SplitN(strings.TrimPrefix(line, "\\"), "  ", 2)
+		if len(fields) != 2 {
+			return fmt.Errorf("invalid checksum line: %s", line)
+		}
+		if escaped {
+			fields[1] = sumsUnescaper.Replace(fields[1])
+		}
+		if err := handle(fields[0], fields[1]); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
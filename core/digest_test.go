@@ -0,0 +1,88 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+// Flashback
+// Copyright 2018 Canonical Ltd.  All rights reserved.
+
+package core_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/CanonicalLtd/flashback/core"
+	check "gopkg.in/check.v1"
+)
+
+func (s *coreSuite) TestVerifySums(c *check.C) {
+	dir := c.MkDir()
+	source := filepath.Join(dir, "system-data")
+	for _, name := range []string{"hostname", "motd", "skipped"} {
+		path := filepath.Join(source, "etc", name)
+		c.Assert(os.MkdirAll(filepath.Dir(path), 0755), check.IsNil)
+		c.Assert(ioutil.WriteFile(path, []byte(name), 0644), check.IsNil)
+	}
+
+	sums := bytes.Buffer{}
+	tw := tar.NewWriter(&bytes.Buffer{})
+	c.Assert(core.TarSums(source, tw, &sums), check.IsNil)
+	c.Assert(strings.Count(sums.String(), "\n"), check.Equals, 3)
+
+	parsed, err := core.ReadSums(bytes.NewReader(sums.Bytes()))
+	c.Assert(err, check.IsNil)
+	c.Assert(parsed, check.HasLen, 3)
+
+	skip := func(name string) bool { return name == "system-data/etc/skipped" }
+	count, err := core.VerifySums(dir, bytes.NewReader(sums.Bytes()), skip)
+	c.Assert(err, check.IsNil)
+	c.Assert(count, check.Equals, 2)
+
+	// A file that changed on the disk
+	c.Assert(ioutil.WriteFile(filepath.Join(source, "etc", "motd"), []byte("worn out"), 0644), check.IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(source, "etc", "skipped"), []byte("changed"), 0644), check.IsNil)
+	_, err = core.VerifySums(dir, bytes.NewReader(sums.Bytes()), skip)
+	c.Assert(err, check.ErrorMatches, "1 of 2 files do not match the recovery image")
+
+	_, err = core.VerifySumsMap(dir, map[string]string{"system-data/etc/hostname": parsed["system-data/etc/hostname"]})
+	c.Assert(err, check.IsNil)
+
+	// A file that is now a link is not followed, even to the same content
+	hostname := filepath.Join(source, "etc", "hostname")
+	c.Assert(os.Rename(hostname, filepath.Join(dir, "hostname")), check.IsNil)
+	c.Assert(os.Symlink(filepath.Join(dir, "hostname"), hostname), check.IsNil)
+	_, err = core.VerifySumsMap(dir, map[string]string{"system-data/etc/hostname": parsed["system-data/etc/hostname"]})
+	c.Assert(err, check.ErrorMatches, "1 of 1 files do not match the recovery image")
+}
+
+func (s *coreSuite) TestSumsEscapedNames(c *check.C) {
+	dir := c.MkDir()
+	source := filepath.Join(dir, "system-data")
+	names := []string{"back\\slash", "new\nline", "plain"}
+	for _, name := range names {
+		c.Assert(os.MkdirAll(source, 0755), check.IsNil)
+		c.Assert(ioutil.WriteFile(filepath.Join(source, name), []byte(name), 0644), check.IsNil)
+	}
+
+	sums := bytes.Buffer{}
+	tw := tar.NewWriter(&bytes.Buffer{})
+	c.Assert(core.TarSums(source, tw, &sums), check.IsNil)
+
+	// The names are escaped as by sha256sum, so each file has one line
+	lines := strings.Split(strings.TrimSuffix(sums.String(), "\n"), "\n")
+	c.Assert(lines, check.HasLen, 3)
+	c.Assert(lines[0], check.Matches, `\\[0-9a-f]{64}  system-data/back\\\\slash`)
+	c.Assert(lines[1], check.Matches, `\\[0-9a-f]{64}  system-data/new\\nline`)
+	c.Assert(lines[2], check.Matches, `[0-9a-f]{64}  system-data/plain`)
+
+	parsed, err := core.ReadSums(bytes.NewReader(sums.Bytes()))
+	c.Assert(err, check.IsNil)
+	for _, name := range names {
+		c.Assert(parsed["system-data/"+name], check.Not(check.Equals), "", check.Commentf(name))
+	}
+
+	count, err := core.VerifySums(dir, bytes.NewReader(sums.Bytes()), nil)
+	c.Assert(err, check.IsNil)
+	c.Assert(count, check.Equals, 3)
+}
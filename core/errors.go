@@ -0,0 +1,84 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+// Flashback
+// Copyright 2018 Canonical Ltd.  All rights reserved.
+
+package core
+
+// Failure is the class of an error, so the caller can react to it
+type Failure int
+
+// Classes of the errors
+const (
+	FailureOther            Failure = iota // unclassified error
+	FailureConfig                          // invalid command line or config file
+	FailureRestoreMissing                  // restore partition not found
+	FailurePartitionMissing                // system-boot or writable partition not found
+	FailureImageMissing                    // recovery image not found
+	FailureImageCorrupt                    // recovery image is truncated or corrupt
+	FailureFormat                          // formatting writable failed
+	FailureRestore                         // restoring the partitions failed after formatting
+	FailureBootprint                       // creating the recovery image failed
+	FailureReadBack                        // a restored partition does not match the recovery image
+)
+
+// Error is an error with its failure class
+type Error struct {
+	Failure Failure
+	Err     error
+}
+
+// Error returns the message of the underlying error
+func (e *Error) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the underlying error, for errors.Is and errors.As
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// NewError classifies an error. An error that is already classified keeps its
+// class, so the first failure is reported
+func NewError(failure Failure, err error) error {
+	if err == nil {
+		return nil
+	}
+	if _, ok := err.(*Error); ok {
+		return err
+	}
+	return &Error{Failure: failure, Err: err}
+}
+
+// FailureOf returns the class of an error
+func FailureOf(err error) Failure {
+	if e, ok := err.(*Error); ok {
+		return e.Failure
+	}
+	return FailureOther
+}
+
+// exitCodes are the exit codes for the classes of failure
+var exitCodes = map[Failure]int{
+	FailureOther:            1,
+	FailureConfig:           2,
+	FailureRestoreMissing:   3,
+	FailurePartitionMissing: 4,
+	FailureImageMissing:     5,
+	FailureImageCorrupt:     6,
+	FailureFormat:           7,
+	FailureRestore:          8,
+	FailureBootprint:        9,
+	FailureReadBack:         10,
+}
+
+// ExitCode converts an error to the exit code of the application. A class
+// without an exit code is reported as any other error
+func ExitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	if code, ok := exitCodes[FailureOf(err)]; ok {
+		return code
+	}
+	return exitCodes[FailureOther]
+}
@@ -0,0 +1,60 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+// Flashback
+// Copyright 2018 Canonical Ltd.  All rights reserved.
+
+package core_test
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/CanonicalLtd/flashback/core"
+	check "gopkg.in/check.v1"
+)
+
+func (s *coreSuite) TestNewError(c *check.C) {
+	raw := errors.New("mount failed")
+	classified := core.NewError(core.FailureFormat, raw)
+
+	tests := []struct {
+		err     error
+		failure core.Failure
+		code    int
+	}{
+		{nil, core.FailureOther, 0},
+		{raw, core.FailureOther, 1},
+		{classified, core.FailureFormat, 7},
+		// The first class is kept
+		{core.NewError(core.FailureRestore, classified), core.FailureFormat, 7},
+		{core.NewError(core.FailureConfig, raw), core.FailureConfig, 2},
+		{core.NewError(core.FailureRestoreMissing, raw), core.FailureRestoreMissing, 3},
+		{core.NewError(core.FailurePartitionMissing, raw), core.FailurePartitionMissing, 4},
+		{core.NewError(core.FailureImageMissing, raw), core.FailureImageMissing, 5},
+		{core.NewError(core.FailureImageCorrupt, raw), core.FailureImageCorrupt, 6},
+		{core.NewError(core.FailureRestore, raw), core.FailureRestore, 8},
+		{core.NewError(core.FailureBootprint, raw), core.FailureBootprint, 9},
+		{core.NewError(core.FailureReadBack, raw), core.FailureReadBack, 10},
+		// A class without an exit code is still a failure
+		{core.NewError(core.Failure(99), raw), core.Failure(99), 1},
+		// A wrapped error is not classified
+		{fmt.Errorf("reset: %v", classified), core.FailureOther, 1},
+	}
+
+	for i, t := range tests {
+		c.Check(core.FailureOf(t.err), check.Equals, t.failure, check.Commentf("test %d", i))
+		c.Check(core.ExitCode(t.err), check.Equals, t.code, check.Commentf("test %d", i))
+	}
+
+	c.Assert(core.NewError(core.FailureRestore, nil), check.IsNil)
+	c.Assert(classified.Error(), check.Equals, "mount failed")
+}
+
+func (s *coreSuite) TestErrorUnwrap(c *check.C) {
+	_, err := os.Open(c.MkDir() + "/missing")
+	classified := core.NewError(core.FailureImageMissing, err)
+
+	c.Assert(errors.Is(classified, os.ErrNotExist), check.Equals, true)
+	var pathErr *os.PathError
+	c.Assert(errors.As(classified, &pathErr), check.Equals, true)
+}
@@ -0,0 +1,17 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+// Flashback
+// Copyright 2018 Canonical Ltd.  All rights reserved.
+
+package core
+
+// PartitionTags returns the tags that are set for a partition, most specific first
+var PartitionTags = partitionTags
+
+// HasReadOnlyFlag checks whether a filesystem type has the read-only feature
+var HasReadOnlyFlag = hasReadOnlyFlag
+
+// FamilyFlag and FamilyUUID build the options of mkfs for a filesystem family
+var (
+	FamilyFlag = familyFlag
+	FamilyUUID = familyUUID
+)
@@ -0,0 +1,239 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+// Flashback
+// Copyright 2018 Canonical Ltd.  All rights reserved.
+
+package core
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/CanonicalLtd/flashback/audit"
+)
+
+const extractBufferSize = 32 * 1024
+
+// ExtractHook handles an entry of a tar stream before it is extracted.
+// Returns true if the entry is handled and is not to be extracted
+type ExtractHook func(header *tar.Header, r io.Reader) (bool, error)
+
+// dirTime is the modification time of an extracted directory
+type dirTime struct {
+	path    string
+	modTime time.Time
+}
+
+// Untar extracts a tar stream to a directory. The entries are streamed one
+// at a time, and each file is closed before the next entry is read, so the
+// file descriptors used do not grow with the archive. Existing entries are
+// replaced. The modification time of a directory is set once the extraction
+// has moved past its entries, as extracting them changes it, so only the
+// directories above the current entry are kept. Archives are written depth
+// first, and an entry that comes back to an earlier directory changes its
+// time again. Returns the number of entries extracted
+func Untar(r io.Reader, dest string, hook ExtractHook) (int, error) {
+	tr := tar.NewReader(r)
+	buffer := make([]byte, extractBufferSize)
+	count := 0
+	dirs := []dirTime{}
+
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			_, err = leaveDirs(dirs, "")
+			return count, err
+		}
+		if err != nil {
+			return count, err
+		}
+
+		if hook != nil {
+			handled, err := hook(header, tr)
+			if err != nil {
+				return count, err
+			}
+			if handled {
+				continue
+			}
+		}
+
+		target, err := extractPath(dest, header.Name)
+		if err != nil {
+			return count, err
+		}
+		if dirs, err = leaveDirs(dirs, target); err != nil {
+			return count, err
+		}
+		if err := extractEntry(tr, header, dest, target, buffer); err != nil {
+			return count, fmt.Errorf("`%s`: %v", header.Name, err)
+		}
+		if header.Typeflag == tar.TypeDir {
+			dirs = append(dirs, dirTime{path: target, modTime: header.ModTime})
+		}
+		count++
+	}
+}
+
+// extractPath is the path of an entry in the directory. Entries must not
+// point outside the directory, or be under a link that was extracted earlier
+// as the link would be followed
+func extractPath(dest, name string) (string, error) {
+	target := filepath.Join(dest, name)
+	root := filepath.Clean(dest)
+	if target == root {
+		return target, nil
+	}
+	if !strings.HasPrefix(target, root+string(filepath.Separator)) {
+		return "", fmt.Errorf("`%s` is outside the archive root", name)
+	}
+
+	parent := root
+	for _, part := range strings.Split(filepath.Dir(strings.TrimPrefix(target, root+string(filepath.Separator))), string(filepath.Separator)) {
+		if part == "." {
+			break
+		}
+		parent = filepath.Join(parent, part)
+		info, err := os.Lstat(parent)
+		if os.IsNotExist(err) {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			return "", fmt.Errorf("`%s` is under a link", name)
+		}
+	}
+	return target, nil
+}
+
+// leaveDirs sets the modification times of the extracted directories that
+// the next entry is not in, deepest first, and returns the ones it is in.
+// An empty path leaves all the directories
+func leaveDirs(dirs []dirTime, next string) ([]dirTime, error) {
+	for len(dirs) > 0 {
+		top := dirs[len(dirs)-1]
+		if len(next) > 0 && strings.HasPrefix(next, top.path+string(filepath.Separator)) {
+			break
+		}
+		if err := os.Chtimes(top.path, top.modTime, top.modTime); err != nil {
+			return dirs, err
+		}
+		dirs = dirs[:len(dirs)-1]
+	}
+	return dirs, nil
+}
+
+func extractEntry(r io.Reader, header *tar.Header, dest, target string, buffer []byte) error {
+	mode := header.FileInfo().Mode()
+
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+
+	switch header.Typeflag {
+	case tar.TypeDir:
+		if err := replaceExisting(target, true); err != nil {
+			return err
+		}
+		if err := os.MkdirAll(target, mode.Perm()); err != nil {
+			return err
+		}
+		// The time is set after the entries of the directory are extracted
+		if err := os.Chmod(target, mode); err != nil {
+			return err
+		}
+		return setOwner(target, header)
+
+	case tar.TypeReg:
+		if err := replaceExisting(target, false); err != nil {
+			return err
+		}
+		if err := extractFile(r, target, mode.Perm(), buffer); err != nil {
+			return err
+		}
+
+	case tar.TypeSymlink:
+		if err := replaceExisting(target, false); err != nil {
+			return err
+		}
+		if err := os.Symlink(header.Linkname, target); err != nil {
+			return err
+		}
+		return setOwner(target, header)
+
+	case tar.TypeLink:
+		source, err := extractPath(dest, header.Linkname)
+		if err != nil {
+			return err
+		}
+		if err := replaceExisting(target, false); err != nil {
+			return err
+		}
+		return os.Link(source, target)
+
+	default:
+		audit.Printf("Skip `%s`: unsupported entry type %c\n", header.Name, header.Typeflag)
+		return nil
+	}
+
+	// Set the mode again, as it was masked by the umask
+	if err := os.Chmod(target, mode); err != nil {
+		return err
+	}
+	if err := setOwner(target, header); err != nil {
+		return err
+	}
+	return os.Chtimes(target, header.ModTime, header.ModTime)
+}
+
+// extractFile writes a file and flushes it to the disk, closing it before returning
+func extractFile(r io.Reader, target string, perm os.FileMode, buffer []byte) error {
+	f, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.CopyBuffer(f, r, buffer); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// replaceExisting removes an existing entry that cannot be overwritten in
+// place. An existing directory is kept for a directory
+func replaceExisting(target string, dir bool) error {
+	info, err := os.Lstat(target)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if info.IsDir() {
+		if dir {
+			return nil
+		}
+		return os.RemoveAll(target)
+	}
+	return os.Remove(target)
+}
+
+// setOwner sets the owner of an entry, when running as root
+func setOwner(target string, header *tar.Header) error {
+	if os.Geteuid() != 0 {
+		return nil
+	}
+	return os.Lchown(target, header.Uid, header.Gid)
+}
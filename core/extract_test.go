@@ -0,0 +1,132 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+// Flashback
+// Copyright 2018 Canonical Ltd.  All rights reserved.
+
+package core_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/CanonicalLtd/flashback/core"
+	check "gopkg.in/check.v1"
+)
+
+func tarEntries(c *check.C, headers []*tar.Header, contents map[string]string) *bytes.Buffer {
+	buf := &bytes.Buffer{}
+	tw := tar.NewWriter(buf)
+	for _, h := range headers {
+		h.Size = int64(len(contents[h.Name]))
+		c.Assert(tw.WriteHeader(h), check.IsNil)
+		_, err := tw.Write([]byte(contents[h.Name]))
+		c.Assert(err, check.IsNil)
+	}
+	c.Assert(tw.Close(), check.IsNil)
+	return buf
+}
+
+func (s *coreSuite) TestUntar(c *check.C) {
+	dest := c.MkDir()
+
+	// Existing entries that are replaced
+	c.Assert(os.MkdirAll(filepath.Join(dest, "system-data", "etc", "hostname"), 0755), check.IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(dest, "system-data", "etc", "motd"), []byte("a much longer message of the day"), 0644), check.IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(dest, "system-data", "etc", "localtime"), []byte("UTC"), 0644), check.IsNil)
+
+	headers := []*tar.Header{
+		{Name: "system-data/", Typeflag: tar.TypeDir, Mode: 0755},
+		{Name: "system-data/etc/hostname", Typeflag: tar.TypeReg, Mode: 0644},
+		{Name: "system-data/etc/motd", Typeflag: tar.TypeReg, Mode: 0600},
+		{Name: "system-data/etc/localtime", Typeflag: tar.TypeSymlink, Linkname: "/usr/share/zoneinfo/UTC", Mode: 0777},
+		{Name: "system-data/etc/motd.link", Typeflag: tar.TypeLink, Linkname: "system-data/etc/motd"},
+		{Name: "special", Typeflag: tar.TypeReg, Mode: 0644},
+	}
+	contents := map[string]string{
+		"system-data/etc/hostname": "device",
+		"system-data/etc/motd":     "hello",
+		"special":                  "handled by the hook",
+	}
+
+	hooked := ""
+	count, err := core.Untar(tarEntries(c, headers, contents), dest, func(h *tar.Header, r io.Reader) (bool, error) {
+		if h.Name != "special" {
+			return false, nil
+		}
+		dat, err := ioutil.ReadAll(r)
+		hooked = string(dat)
+		return true, err
+	})
+	c.Assert(err, check.IsNil)
+	c.Assert(count, check.Equals, 5)
+	c.Assert(hooked, check.Equals, "handled by the hook")
+
+	dat, err := ioutil.ReadFile(filepath.Join(dest, "system-data", "etc", "hostname"))
+	c.Assert(err, check.IsNil)
+	c.Assert(string(dat), check.Equals, "device")
+
+	// The longer file is truncated
+	dat, err = ioutil.ReadFile(filepath.Join(dest, "system-data", "etc", "motd.link"))
+	c.Assert(err, check.IsNil)
+	c.Assert(string(dat), check.Equals, "hello")
+	info, err := os.Stat(filepath.Join(dest, "system-data", "etc", "motd"))
+	c.Assert(err, check.IsNil)
+	c.Assert(info.Mode().Perm(), check.Equals, os.FileMode(0600))
+
+	link, err := os.Readlink(filepath.Join(dest, "system-data", "etc", "localtime"))
+	c.Assert(err, check.IsNil)
+	c.Assert(link, check.Equals, "/usr/share/zoneinfo/UTC")
+
+	_, err = os.Stat(filepath.Join(dest, "special"))
+	c.Assert(os.IsNotExist(err), check.Equals, true)
+}
+
+func (s *coreSuite) TestUntarOutside(c *check.C) {
+	headers := []*tar.Header{{Name: "../escape", Typeflag: tar.TypeReg, Mode: 0644}}
+	_, err := core.Untar(tarEntries(c, headers, nil), c.MkDir(), nil)
+	c.Assert(err, check.ErrorMatches, "`../escape` is outside the archive root")
+}
+
+func (s *coreSuite) TestUntarUnderLink(c *check.C) {
+	dest := c.MkDir()
+	outside := c.MkDir()
+
+	headers := []*tar.Header{
+		{Name: "system-data/etc", Typeflag: tar.TypeSymlink, Linkname: outside, Mode: 0777},
+		{Name: "system-data/etc/passwd", Typeflag: tar.TypeReg, Mode: 0644},
+	}
+	_, err := core.Untar(tarEntries(c, headers, map[string]string{"system-data/etc/passwd": "root"}), dest, nil)
+	c.Assert(err, check.ErrorMatches, "`system-data/etc/passwd` is under a link")
+
+	_, err = os.Stat(filepath.Join(outside, "passwd"))
+	c.Assert(os.IsNotExist(err), check.Equals, true)
+}
+
+func (s *coreSuite) TestUntarDirTimes(c *check.C) {
+	dest := c.MkDir()
+	past := time.Now().Add(-time.Hour).Truncate(time.Second)
+
+	headers := []*tar.Header{
+		{Name: "system-data", Typeflag: tar.TypeDir, Mode: 0755, ModTime: past},
+		{Name: "system-data/etc", Typeflag: tar.TypeDir, Mode: 0755, ModTime: past},
+		{Name: "system-data/etc/hostname", Typeflag: tar.TypeReg, Mode: 0644, ModTime: past},
+		{Name: "system-data/etc/localtime", Typeflag: tar.TypeSymlink, Linkname: "/usr/share/zoneinfo/UTC", Mode: 0777, ModTime: past},
+		{Name: "system-data/var", Typeflag: tar.TypeDir, Mode: 0755, ModTime: past},
+		{Name: "system-data/var/log", Typeflag: tar.TypeDir, Mode: 0755, ModTime: past},
+		{Name: "system-data/var/log/syslog", Typeflag: tar.TypeReg, Mode: 0644, ModTime: past},
+		{Name: "system-data/var/snap", Typeflag: tar.TypeDir, Mode: 0755, ModTime: past},
+	}
+	contents := map[string]string{"system-data/etc/hostname": "device", "system-data/var/log/syslog": "boot"}
+	_, err := core.Untar(tarEntries(c, headers, contents), dest, nil)
+	c.Assert(err, check.IsNil)
+
+	for _, name := range []string{"system-data", "system-data/etc", "system-data/etc/hostname", "system-data/var", "system-data/var/log", "system-data/var/snap"} {
+		info, err := os.Stat(filepath.Join(dest, name))
+		c.Assert(err, check.IsNil)
+		c.Assert(info.ModTime().Equal(past), check.Equals, true, check.Commentf("%s", name))
+	}
+}
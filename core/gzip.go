@@ -0,0 +1,198 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+// Flashback
+// Copyright 2018 Canonical Ltd.  All rights reserved.
+
+package core
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"runtime"
+	"sync"
+)
+
+// Block compression in the style of pigz: the input is split into blocks that
+// are deflated in parallel, each primed with the end of the previous block as
+// its dictionary. The blocks end on a byte boundary, so they join up into a
+// single deflate stream and the output is a standard gzip file
+const (
+	gzipBlockSize = 128 * 1024
+	gzipDictSize  = 32 * 1024
+)
+
+// NewGzipWriter creates a gzip writer that compresses with a number of
+// workers. Zero workers uses all the CPUs. The writer must be closed
+func NewGzipWriter(w io.Writer, workers int) io.WriteCloser {
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	if workers == 1 {
+		return gzip.NewWriter(w)
+	}
+
+	z := &parallelGzipWriter{
+		w:       w,
+		block:   make([]byte, 0, gzipBlockSize),
+		pending: make(chan chan gzipBlock, workers),
+		done:    make(chan struct{}),
+	}
+	go z.writeBlocks()
+	return z
+}
+
+var errGzipClosed = errors.New("gzip: write to a closed writer")
+
+// gzipBlock is a compressed block, or the error compressing it
+type gzipBlock struct {
+	data []byte
+	err  error
+}
+
+type parallelGzipWriter struct {
+	w      io.Writer
+	block  []byte
+	dict   []byte
+	crc    uint32
+	size   uint32
+	closed bool
+
+	// The compressed blocks in the order of the input
+	pending chan chan gzipBlock
+	done    chan struct{}
+
+	mu  sync.Mutex
+	err error
+}
+
+// Write adds data to the current block, starting compression of the full blocks
+func (z *parallelGzipWriter) Write(p []byte) (int, error) {
+	if z.closed {
+		return 0, errGzipClosed
+	}
+	if err := z.error(); err != nil {
+		return 0, err
+	}
+
+	z.crc = crc32.Update(z.crc, crc32.IEEETable, p)
+	z.size += uint32(len(p))
+
+	n := 0
+	for len(p) > 0 {
+		count := copy(z.block[len(z.block):cap(z.block)], p)
+		z.block = z.block[:len(z.block)+count]
+		p = p[count:]
+		n += count
+
+		if len(z.block) == cap(z.block) {
+			z.compress(false)
+		}
+	}
+	return n, nil
+}
+
+// Close compresses the last block and writes the gzip trailer
+func (z *parallelGzipWriter) Close() error {
+	if z.closed {
+		return z.error()
+	}
+	z.closed = true
+
+	z.compress(true)
+	close(z.pending)
+	<-z.done
+
+	if err := z.error(); err != nil {
+		return err
+	}
+
+	trailer := make([]byte, 8)
+	binary.LittleEndian.PutUint32(trailer[0:4], z.crc)
+	binary.LittleEndian.PutUint32(trailer[4:8], z.size)
+	_, err := z.w.Write(trailer)
+	return err
+}
+
+// compress starts the compression of the current block. The number of blocks
+// in progress is limited by the size of the pending queue
+func (z *parallelGzipWriter) compress(last bool) {
+	result := make(chan gzipBlock, 1)
+	z.pending <- result
+
+	block, dict := z.block, z.dict
+	go func() {
+		data, err := deflateBlock(block, dict, last)
+		result <- gzipBlock{data: data, err: err}
+	}()
+
+	// The end of this block is the dictionary of the next
+	if len(block) > gzipDictSize {
+		z.dict = block[len(block)-gzipDictSize:]
+	} else {
+		z.dict = block
+	}
+	z.block = make([]byte, 0, gzipBlockSize)
+}
+
+// writeBlocks writes the gzip header and the compressed blocks in order
+func (z *parallelGzipWriter) writeBlocks() {
+	defer close(z.done)
+
+	header := []byte{0x1f, 0x8b, 8, 0, 0, 0, 0, 0, 0, 255}
+	if _, err := z.w.Write(header); err != nil {
+		z.setError(err)
+	}
+
+	// Keep reading after an error, so the compression does not block
+	for result := range z.pending {
+		b := <-result
+		if z.error() != nil {
+			continue
+		}
+		if b.err != nil {
+			z.setError(b.err)
+			continue
+		}
+		if _, err := z.w.Write(b.data); err != nil {
+			z.setError(err)
+		}
+	}
+}
+
+func (z *parallelGzipWriter) error() error {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+	return z.err
+}
+
+func (z *parallelGzipWriter) setError(err error) {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+	if z.err == nil {
+		z.err = err
+	}
+}
+
+// deflateBlock compresses a block. Blocks end with a sync flush, so the next
+// block starts on a byte boundary, and the last block ends the stream
+func deflateBlock(block, dict []byte, last bool) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	fw, err := flate.NewWriterDict(buf, flate.DefaultCompression, dict)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := fw.Write(block); err != nil {
+		return nil, err
+	}
+
+	if last {
+		err = fw.Close()
+	} else {
+		err = fw.Flush()
+	}
+	return buf.Bytes(), err
+}
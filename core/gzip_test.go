@@ -0,0 +1,58 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+// Flashback
+// Copyright 2018 Canonical Ltd.  All rights reserved.
+
+package core_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"math/rand"
+
+	"github.com/CanonicalLtd/flashback/core"
+	check "gopkg.in/check.v1"
+)
+
+func (s *coreSuite) TestNewGzipWriter(c *check.C) {
+	// Text that compresses, with random data across the block boundaries
+	content := bytes.Repeat([]byte("flashback factory reset "), 20000)
+	random := make([]byte, 300*1024)
+	rand.New(rand.NewSource(1)).Read(random)
+	content = append(content, random...)
+	content = append(content, content[:100000]...)
+
+	for _, data := range [][]byte{content, content[:1000], {}} {
+		for _, workers := range []int{0, 1, 2, 4} {
+			buf := bytes.Buffer{}
+			gw := core.NewGzipWriter(&buf, workers)
+
+			// Write in uneven pieces
+			for i := 0; i < len(data); i += 50000 {
+				end := i + 50000
+				if end > len(data) {
+					end = len(data)
+				}
+				_, err := gw.Write(data[i:end])
+				c.Assert(err, check.IsNil)
+			}
+			c.Assert(gw.Close(), check.IsNil)
+
+			gr, err := gzip.NewReader(&buf)
+			c.Assert(err, check.IsNil)
+			out, err := ioutil.ReadAll(gr)
+			c.Assert(err, check.IsNil, check.Commentf("workers: %d", workers))
+			c.Assert(bytes.Equal(out, data), check.Equals, true, check.Commentf("workers: %d", workers))
+		}
+	}
+}
+
+func (s *coreSuite) TestGzipWriterClosed(c *check.C) {
+	for _, workers := range []int{1, 4} {
+		gw := core.NewGzipWriter(&bytes.Buffer{}, workers)
+		c.Assert(gw.Close(), check.IsNil)
+		c.Assert(gw.Close(), check.IsNil)
+		_, err := gw.Write([]byte("after close"))
+		c.Assert(err, check.NotNil, check.Commentf("workers: %d", workers))
+	}
+}
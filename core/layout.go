@@ -0,0 +1,139 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+// Flashback
+// Copyright 2018 Canonical Ltd.  All rights reserved.
+
+package core
+
+import (
+	"path/filepath"
+
+	"github.com/CanonicalLtd/flashback/audit"
+	"github.com/CanonicalLtd/flashback/config"
+)
+
+// Partitions of the Ubuntu Core 20 layout
+const (
+	PartitionUbuntuSeed = "ubuntu-seed"
+	PartitionUbuntuBoot = "ubuntu-boot"
+	PartitionUbuntuSave = "ubuntu-save"
+	PartitionUbuntuData = "ubuntu-data"
+)
+
+// RolesPath is the directory where the partitions of the Ubuntu Core 20
+// layout are mounted, each in a directory named after its role
+var RolesPath = config.DefaultRolesMount
+
+// Role is a partition of the Ubuntu Core 20 layout and what a factory reset does with it
+type Role struct {
+	Name   string
+	Device string
+	Action string
+}
+
+// Backup is the path of the image or archive of the partition on the restore partition
+func (r Role) Backup() string {
+	if r.Action == config.RoleArchive {
+		return restoreFilePath(r.Name + ".tar.gz")
+	}
+	return restoreFilePath(r.Name + ".img.gz")
+}
+
+// MountPath is the mount point of the partition
+func (r Role) MountPath() string {
+	return filepath.Join(RolesPath, r.Name)
+}
+
+// Role finds a partition of the Ubuntu Core 20 layout
+func (p Partition) Role(name string) (Role, bool) {
+	for _, r := range p.Roles {
+		if r.Name == name {
+			return r, true
+		}
+	}
+	return Role{}, false
+}
+
+// ExtraRoles are the partitions that are imaged or archived besides
+// ubuntu-boot and ubuntu-data, which take the places of system-boot and writable
+func (p Partition) ExtraRoles() []Role {
+	roles := []Role{}
+	for _, r := range p.Roles {
+		if r.Name == PartitionUbuntuBoot || r.Name == PartitionUbuntuData || r.Action == config.RoleKeep {
+			continue
+		}
+		roles = append(roles, r)
+	}
+	return roles
+}
+
+// SystemBootImaged is whether the recovery image has an image of system-boot,
+// or ubuntu-boot in the Ubuntu Core 20 layout. The boot slots have images of their own
+func SystemBootImaged() bool {
+	if len(config.Store.Slots.Partitions) > 0 {
+		return false
+	}
+	return config.Store.Layout != config.LayoutUC20 || config.Store.Roles.UbuntuBoot == config.RoleImage
+}
+
+// WritablePartition returns the name and the config of the partition that
+// holds system-data: writable, or ubuntu-data in the Ubuntu Core 20 layout
+func WritablePartition() (string, config.Partition) {
+	if config.Store.Layout == config.LayoutUC20 {
+		return PartitionUbuntuData, config.Store.Partitions.UbuntuData
+	}
+	return PartitionWritable, config.Store.Partitions.Writable
+}
+
+// findUC20Partitions locates the partitions of the Ubuntu Core 20 layout.
+// Partitions that are kept do not have to exist
+func findUC20Partitions() error {
+	restore, err := findPartition(PartitionRestore, config.Store.Partitions.Restore)
+	if err != nil {
+		return NewError(FailureRestoreMissing, err)
+	}
+
+	table := Partition{Restore: restore}
+	roles := []struct {
+		name   string
+		p      config.Partition
+		action string
+	}{
+		{PartitionUbuntuSeed, config.Store.Partitions.UbuntuSeed, config.Store.Roles.UbuntuSeed},
+		{PartitionUbuntuBoot, config.Store.Partitions.UbuntuBoot, config.Store.Roles.UbuntuBoot},
+		{PartitionUbuntuSave, config.Store.Partitions.UbuntuSave, config.Store.Roles.UbuntuSave},
+		{PartitionUbuntuData, config.Store.Partitions.UbuntuData, config.Store.Roles.UbuntuData},
+	}
+	for _, r := range roles {
+		device, err := findPartition(r.name, r.p)
+		if err != nil {
+			if r.action != config.RoleKeep {
+				return NewError(FailurePartitionMissing, err)
+			}
+			audit.Printf("The %s partition is kept, continue without it\n", r.name)
+		}
+		table.Roles = append(table.Roles, Role{Name: r.name, Device: device, Action: r.action})
+
+		switch {
+		case r.name == PartitionUbuntuData:
+			table.Writable = device
+		case r.name == PartitionUbuntuBoot && r.action == config.RoleImage:
+			table.SystemBoot = device
+		}
+	}
+
+	PartitionTable = table
+	return nil
+}
+
+// BootPartition is the device of the partition with the boot environment:
+// system-boot, ubuntu-boot in the Ubuntu Core 20 layout even if it is kept,
+// or the boot slot that holds the environment
+func (p Partition) BootPartition() string {
+	if r, ok := p.Role(PartitionUbuntuBoot); ok {
+		return r.Device
+	}
+	if s, ok := p.Slot(config.Store.Slots.Env); ok {
+		return s.Device
+	}
+	return p.SystemBoot
+}
@@ -0,0 +1,63 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+// Flashback
+// Copyright 2018 Canonical Ltd.  All rights reserved.
+
+package core_test
+
+import (
+	"path/filepath"
+
+	"github.com/CanonicalLtd/flashback/config"
+	"github.com/CanonicalLtd/flashback/core"
+	check "gopkg.in/check.v1"
+)
+
+func (s *coreSuite) TestRoles(c *check.C) {
+	table := core.Partition{
+		SystemBoot: "/dev/sda3",
+		Roles: []core.Role{
+			{Name: core.PartitionUbuntuSeed, Device: "/dev/sda2", Action: config.RoleKeep},
+			{Name: core.PartitionUbuntuBoot, Device: "/dev/sda3", Action: config.RoleImage},
+			{Name: core.PartitionUbuntuSave, Device: "/dev/sda4", Action: config.RoleArchive},
+			{Name: core.PartitionUbuntuData, Device: "/dev/sda5", Action: config.RoleArchive},
+		},
+	}
+
+	// Only the partitions besides ubuntu-boot and ubuntu-data that are not kept
+	extra := table.ExtraRoles()
+	c.Assert(extra, check.HasLen, 1)
+	c.Assert(extra[0].Name, check.Equals, core.PartitionUbuntuSave)
+	c.Assert(extra[0].Backup(), check.Equals, filepath.Join(core.RestorePath, "ubuntu-save.tar.gz"))
+	c.Assert(extra[0].MountPath(), check.Equals, filepath.Join(core.RolesPath, "ubuntu-save"))
+
+	image := core.Role{Name: core.PartitionUbuntuSeed, Action: config.RoleImage}
+	c.Assert(image.Backup(), check.Equals, filepath.Join(core.RestorePath, "ubuntu-seed.img.gz"))
+
+	// ubuntu-boot has the boot environment even if it is kept
+	table.SystemBoot = ""
+	table.Roles[1].Action = config.RoleKeep
+	c.Assert(table.BootPartition(), check.Equals, "/dev/sda3")
+	c.Assert(core.Partition{SystemBoot: "/dev/mmcblk0p1"}.BootPartition(), check.Equals, "/dev/mmcblk0p1")
+}
+
+func (s *coreSuite) TestSlots(c *check.C) {
+	table := core.Partition{
+		Slots: []core.Slot{
+			{Name: "a", Device: "/dev/mmcblk0p1"},
+			{Name: "b", Device: "/dev/mmcblk0p2"},
+		},
+	}
+
+	slot, ok := table.Slot("b")
+	c.Assert(ok, check.Equals, true)
+	c.Assert(slot.Device, check.Equals, "/dev/mmcblk0p2")
+	c.Assert(slot.Image(), check.Equals, filepath.Join(core.RestorePath, "system-boot-b.img.gz"))
+
+	_, ok = table.Slot("c")
+	c.Assert(ok, check.Equals, false)
+
+	// The boot environment is on the slot named in the config
+	config.Store.Slots.Env = "b"
+	defer func() { config.Store.Slots.Env = "" }()
+	c.Assert(table.BootPartition(), check.Equals, "/dev/mmcblk0p2")
+}
@@ -8,43 +8,172 @@ import (
 	"archive/tar"
 	"bufio"
 	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"io"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strconv"
 	"strings"
 
 	"github.com/CanonicalLtd/flashback/audit"
+	"github.com/CanonicalLtd/flashback/config"
 )
 
 // Constants for saving the system image
 const (
-	PartitionSystemBoot   = "system-boot"
-	PartitionRestore      = "restore"
-	PartitionWritable     = "writable"
-	BackupImageWritable   = "/restore/writable.tar.gz"
-	BackupImageSystemBoot = "/restore/system-boot.img.gz"
-	RestorePath           = "/restore"
-	WritablePath          = "/writable"
-	SystemDataPath        = "/restore/system-data"
-	SystemData            = "system-data"
-	TempBackupPath        = "/tmp/flashbackup"
-	TempFSMount           = "/mnt/tmprestore"
-	MMCPrefix             = "mmcblk"
+	PartitionSystemBoot = "system-boot"
+	PartitionRestore    = "restore"
+	PartitionWritable   = "writable"
+	SystemDataPath      = "/restore/system-data"
+	SystemData          = "system-data"
+	TempBackupPath      = "/tmp/flashbackup"
+	MMCPrefix           = "mmcblk"
+	ManifestFileName    = "manifest.yaml"
+	HistoryFileName     = "history.jsonl"
+	TokensFileName      = "cmdline-tokens"
+)
+
+// Mount points and paths for saving the system image, set from the config by SetPaths
+var (
+	BackupImageWritable   = filepath.Join(config.DefaultRestoreMount, config.DefaultWritableArchive)
+	BackupImageSystemBoot = filepath.Join(config.DefaultRestoreMount, config.DefaultSystemBootImage)
+	RestorePath           = config.DefaultRestoreMount
+	WritablePath          = config.DefaultWritableMount
+	TempFSMount           = config.DefaultTmpfsMount
+	SystemBootPath        = config.DefaultSystemBootMount
+	ManifestFile          = filepath.Join(config.DefaultRestoreMount, ManifestFileName)
+	HistoryFile           = filepath.Join(config.DefaultRestoreMount, HistoryFileName)
+	TokensFile            = filepath.Join(config.DefaultRestoreMount, TokensFileName)
+)
+
+// SetPaths sets the mount points and the paths of the system image from the config.
+// Relative paths of the backup files are on the restore partition
+func SetPaths() {
+	RestorePath = config.Store.Paths.RestoreMount
+	WritablePath = config.Store.Paths.WritableMount
+	TempFSMount = config.Store.Paths.TmpfsMount
+	SystemBootPath = config.Store.Paths.SystemBootMount
+	RolesPath = config.Store.Paths.RolesMount
+	BackupImageWritable = restoreFilePath(config.Store.Paths.WritableArchive)
+	BackupImageSystemBoot = restoreFilePath(config.Store.Paths.SystemBootImage)
+	ManifestFile = restoreFilePath(ManifestFileName)
+	HistoryFile = restoreFilePath(HistoryFileName)
+	TokensFile = restoreFilePath(TokensFileName)
+}
+
+// restoreFilePath converts a path on the restore partition to its mounted path
+func restoreFilePath(path string) string {
+	if filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(RestorePath, path)
+}
+
+// Tags used by blkid and findfs to identify a partition
+const (
+	TagLabel     = "LABEL"
+	TagUUID      = "UUID"
+	TagPartUUID  = "PARTUUID"
+	TagPartLabel = "PARTLABEL"
 )
 
 // FindFS locates a filesystem by label
 func FindFS(label string) (string, error) {
-	out, err := exec.Command("findfs", fmt.Sprintf("LABEL=%s", label)).CombinedOutput()
+	return FindFSByTag(TagLabel, label)
+}
+
+// FindFSByTag locates a filesystem by a tag e.g. LABEL, UUID, PARTUUID
+func FindFSByTag(tag, value string) (string, error) {
+	out, err := exec.Command("findfs", fmt.Sprintf("%s=%s", tag, value)).CombinedOutput()
 
 	// Remove non-printable characters from the response
 	cleaned := cleanOutput(string(out))
 	return cleaned, err
 }
 
-// FormatDisk formats and labels a disk
-func FormatDisk(path, fstype, label string) error {
+// FSTag retrieves the value of a tag e.g. UUID, for a partition
+func FSTag(device, tag string) (string, error) {
+	out, err := exec.Command("blkid", "-o", "value", "-s", tag, device).Output()
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// FindPartition locates a partition from its identifiers. The explicit
+// device path is preferred, followed by the partition and filesystem tags
+func FindPartition(p config.Partition) (string, error) {
+	tags := partitionTags(p)
+
+	var device string
+	if len(p.Device) > 0 {
+		// Resolve links e.g. /dev/disk/by-path/... to the device node
+		d, err := filepath.EvalSymlinks(p.Device)
+		if err != nil {
+			return "", err
+		}
+		device = d
+	} else {
+		if len(tags) == 0 {
+			return "", fmt.Errorf("no identifier is set for the partition")
+		}
+		d, err := FindFSByTag(tags[0][0], tags[0][1])
+		if err != nil {
+			return "", err
+		}
+		device = d
+		tags = tags[1:]
+	}
+
+	// Check that the other identifiers match the device
+	for _, t := range tags {
+		value, err := FSTag(device, t[0])
+		if err != nil {
+			return "", fmt.Errorf("cannot read %s of `%s`: %v", t[0], device, err)
+		}
+		if !strings.EqualFold(value, t[1]) {
+			return "", fmt.Errorf("%s of `%s` is `%s`, expected `%s`", t[0], device, value, t[1])
+		}
+	}
+
+	return device, nil
+}
+
+// partitionTags returns the tags that are set for a partition, most specific first
+func partitionTags(p config.Partition) [][2]string {
+	tags := [][2]string{}
+	if len(p.PartUUID) > 0 {
+		tags = append(tags, [2]string{TagPartUUID, p.PartUUID})
+	}
+	if len(p.UUID) > 0 {
+		tags = append(tags, [2]string{TagUUID, p.UUID})
+	}
+	if len(p.PartLabel) > 0 {
+		tags = append(tags, [2]string{TagPartLabel, p.PartLabel})
+	}
+	if len(p.Label) > 0 {
+		tags = append(tags, [2]string{TagLabel, p.Label})
+	}
+	return tags
+}
+
+// describePartition formats the identifiers of a partition for the log
+func describePartition(p config.Partition) string {
+	desc := []string{}
+	if len(p.Device) > 0 {
+		desc = append(desc, p.Device)
+	}
+	for _, t := range partitionTags(p) {
+		desc = append(desc, fmt.Sprintf("%s=%s", t[0], t[1]))
+	}
+	return strings.Join(desc, " ")
+}
+
+// FormatDisk formats and labels a disk, keeping the filesystem UUID if one is provided
+func FormatDisk(path, fstype, label, uuid string) error {
 	family := fsFamily(fstype)
 	mkfsCmd := mkfsCommand(fstype)
 
@@ -58,7 +187,7 @@ func FormatDisk(path, fstype, label string) error {
 			audit.Println(err)
 		} else {
 			cmd = append(cmd, optSector)
-			cmd = append(cmd, string(logSec))
+			cmd = append(cmd, strconv.Itoa(logSec))
 		}
 	}
 
@@ -81,6 +210,17 @@ func FormatDisk(path, fstype, label string) error {
 		}
 	}
 
+	if len(uuid) > 0 {
+		// Keep the UUID of the filesystem so it can still be found
+		optUUID, err := familyFlag("uuid", family)
+		if err != nil {
+			audit.Println(err)
+		} else {
+			cmd = append(cmd, optUUID)
+			cmd = append(cmd, familyUUID(uuid, family))
+		}
+	}
+
 	// Add the path to the command
 	cmd = append(cmd, path)
 
@@ -94,13 +234,14 @@ func FormatDisk(path, fstype, label string) error {
 	return nil
 }
 
-// ReadAndGzipToFile reads a file/device, zips it and writes it to a file
-func ReadAndGzipToFile(inFile, outFile string) error {
+// ReadAndGzipToFile reads a file/device, zips it and writes it to a file.
+// Returns the size and checksum of the content
+func ReadAndGzipToFile(inFile, outFile string) (Digest, error) {
 	// Open the input file
 	fIn, err := os.Open(inFile)
 	if err != nil {
 		audit.Println("Error backing up system-boot (open input):", err)
-		return err
+		return Digest{}, err
 	}
 	defer fIn.Close()
 
@@ -108,28 +249,37 @@ func ReadAndGzipToFile(inFile, outFile string) error {
 	fOut, err := os.Create(outFile)
 	if err != nil {
 		audit.Println("Error backing up system-boot (open output):", err)
-		return err
+		return Digest{}, err
 	}
 	defer fOut.Close()
 
-	// Read from the input and gzip it
+	// Read from the input and gzip it on all the workers
 	buffer := bufio.NewReader(fIn)
-	gw := gzip.NewWriter(fOut)
+	gw := NewGzipWriter(fOut, config.Store.Compression.Workers)
+	h := sha256.New()
 
 	// Take the buffered input and write it to the output file via gzip
-	n, err := io.Copy(gw, buffer)
-	gw.Close()
+	n, err := io.Copy(io.MultiWriter(gw, h), buffer)
+	if errClose := gw.Close(); err == nil {
+		err = errClose
+	}
 	audit.Printf("%d bytes read, compressed and written to file", n)
-	return err
+	if err != nil {
+		return Digest{}, err
+	}
+
+	// Make sure the image is on the disk
+	return Digest{Size: n, SHA256: hex.EncodeToString(h.Sum(nil))}, fOut.Sync()
 }
 
-// UnzipToDevice reads gzip file and decompresses it to a device
-func UnzipToDevice(inFile, device string) error {
+// UnzipToDevice reads gzip file and decompresses it to a device. Returns the
+// size and checksum of the content that was written
+func UnzipToDevice(inFile, device string) (Digest, error) {
 	// Open the input file
 	fIn, err := os.Open(inFile)
 	if err != nil {
 		audit.Println("Error restoring system-boot (open input):", err)
-		return err
+		return Digest{}, err
 	}
 	defer fIn.Close()
 
@@ -137,7 +287,7 @@ func UnzipToDevice(inFile, device string) error {
 	fOut, err := os.Create(device)
 	if err != nil {
 		audit.Println("Error restoring system-boot (open output):", err)
-		return err
+		return Digest{}, err
 	}
 	defer fOut.Close()
 
@@ -145,25 +295,52 @@ func UnzipToDevice(inFile, device string) error {
 	gr, err := gzip.NewReader(fIn)
 	if err != nil {
 		audit.Println("Error restoring system-boot (gzip reader):", err)
-		return err
+		return Digest{}, err
 	}
 	buffer := bufio.NewWriter(fOut)
+	h := sha256.New()
 
 	// Take the gzipped input and write it to the output device
-	n, err := io.Copy(buffer, gr)
+	n, err := io.Copy(io.MultiWriter(buffer, h), gr)
 	gr.Close()
 	audit.Printf("%d bytes read, uncompressed and written to device", n)
-	return err
+	if err != nil {
+		return Digest{}, err
+	}
+
+	// Write the tail of the image and make sure it is on the device
+	if err := buffer.Flush(); err != nil {
+		audit.Println("Error restoring system-boot (flush):", err)
+		return Digest{}, err
+	}
+	if err := fOut.Sync(); err != nil {
+		audit.Println("Error restoring system-boot (sync):", err)
+		return Digest{}, err
+	}
+	if err := fOut.Close(); err != nil {
+		return Digest{}, err
+	}
+	return Digest{Size: n, SHA256: hex.EncodeToString(h.Sum(nil))}, FlushDevice(device)
 }
 
 // Mount mounts the device at a path
 func Mount(device, target string) error {
+	return mount(device, target)
+}
+
+// MountReadOnly mounts the device read-only at a path
+func MountReadOnly(device, target string) error {
+	return mount(device, target, "-o", "ro")
+}
+
+func mount(device, target string, options ...string) error {
 	_ = os.MkdirAll(target, os.ModePerm)
 
 	// Unmount the device, just in case
 	_ = Unmount(device)
 
-	out, err := exec.Command("mount", device, target).CombinedOutput()
+	args := append(options, device, target)
+	out, err := exec.Command("mount", args...).CombinedOutput()
 	if len(out) > 0 {
 		audit.Println("Mount the device as", target)
 		audit.Println(string(out))
@@ -193,6 +370,17 @@ func CopyDirectory(sourceDir, destDir string) (err error) {
 	return err
 }
 
+// CopyDirectoryContents copies the contents of a directory, so the target
+// directory becomes a copy of the source
+func CopyDirectoryContents(sourceDir, destDir string) error {
+	// Make sure the target path exists
+	_ = os.MkdirAll(destDir, os.ModePerm)
+
+	out, err := exec.Command("cp", "-arv", sourceDir+"/.", destDir).Output()
+	audit.Println(string(out))
+	return err
+}
+
 // CopyFile from one location to another
 func CopyFile(source, target string) error {
 	// Make sure the target path exists
@@ -203,6 +391,21 @@ func CopyFile(source, target string) error {
 	return err
 }
 
+// DirSize adds up the sizes of the regular files in a directory
+func DirSize(dir string) (int64, error) {
+	var size int64
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.Mode().IsRegular() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size, err
+}
+
 // CreateTmpfsDisk creates a RAM disk of a fixed size
 func CreateTmpfsDisk(mount string, size int) error {
 	audit.Println("Create a RAM disk of size", size, "bytes")
@@ -225,6 +428,12 @@ func FSType(device string) (string, error) {
 
 // Tar creates a tarball from a file or directory structure
 func Tar(source string, tarball *tar.Writer) error {
+	return TarSums(source, tarball, nil)
+}
+
+// TarSums creates a tarball like Tar, and writes the checksums of the files
+// to a sums file if one is given
+func TarSums(source string, tarball *tar.Writer, sums io.Writer) error {
 	// Check that the source exists
 	info, err := os.Stat(source)
 	if err != nil {
@@ -241,7 +450,7 @@ func Tar(source string, tarball *tar.Writer) error {
 			if err != nil {
 				return err
 			}
-			header, err := tar.FileInfoHeader(info, info.Name())
+			header, err := fileHeader(path, info)
 			if err != nil {
 				return err
 			}
@@ -262,16 +471,22 @@ func Tar(source string, tarball *tar.Writer) error {
 				return nil
 			}
 
-			file, err := os.Open(path)
-			if err != nil {
-				return err
-			}
-			defer file.Close()
-			_, err = io.Copy(tarball, file)
-			return err
+			return copyFileTo(tarball, path, header.Name, sums)
 		})
 }
 
+// fileHeader creates the tar header of a file, with the target of a symlink
+func fileHeader(path string, info os.FileInfo) (*tar.Header, error) {
+	link := ""
+	if info.Mode()&os.ModeSymlink != 0 {
+		var err error
+		if link, err = os.Readlink(path); err != nil {
+			return nil, err
+		}
+	}
+	return tar.FileInfoHeader(info, link)
+}
+
 func sectorSize(path string) int {
 	out, err := exec.Command(
 		"blkid", "-i", "-o", "value", "-s", "LOGICAL_SECTOR_SIZE", path).Output()
@@ -0,0 +1,54 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+// Flashback
+// Copyright 2018 Canonical Ltd.  All rights reserved.
+
+package core_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/CanonicalLtd/flashback/config"
+	"github.com/CanonicalLtd/flashback/core"
+	check "gopkg.in/check.v1"
+)
+
+func (s *coreSuite) TestPartitionTags(c *check.C) {
+	p := config.Partition{
+		Device:    "/dev/mmcblk0p3",
+		Label:     "data",
+		UUID:      "2a6c9d7e-0f5b-4b4c-9b7a-5a3c1d4e8f10",
+		PartUUID:  "6f3ad1f0-03",
+		PartLabel: "userdata",
+	}
+	c.Assert(core.PartitionTags(p), check.DeepEquals, [][2]string{
+		{core.TagPartUUID, "6f3ad1f0-03"},
+		{core.TagUUID, "2a6c9d7e-0f5b-4b4c-9b7a-5a3c1d4e8f10"},
+		{core.TagPartLabel, "userdata"},
+		{core.TagLabel, "data"},
+	})
+
+	c.Assert(core.PartitionTags(config.Partition{Device: "/dev/sda3"}), check.HasLen, 0)
+	c.Assert(core.PartitionTags(config.Partition{Label: "writable"}), check.DeepEquals, [][2]string{{core.TagLabel, "writable"}})
+}
+
+func (s *coreSuite) TestFindPartitionByDevice(c *check.C) {
+	dir := c.MkDir()
+	device := filepath.Join(dir, "mmcblk0p3")
+	c.Assert(ioutil.WriteFile(device, nil, 0644), check.IsNil)
+
+	// Links e.g. /dev/disk/by-path/... resolve to the device node
+	link := filepath.Join(dir, "platform-fe340000.mmc-part3")
+	c.Assert(os.Symlink(device, link), check.IsNil)
+
+	found, err := core.FindPartition(config.Partition{Device: link})
+	c.Assert(err, check.IsNil)
+	c.Assert(found, check.Equals, device)
+
+	_, err = core.FindPartition(config.Partition{Device: filepath.Join(dir, "missing")})
+	c.Assert(err, check.NotNil)
+
+	_, err = core.FindPartition(config.Partition{})
+	c.Assert(err, check.ErrorMatches, "no identifier is set for the partition")
+}
@@ -0,0 +1,62 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+// Flashback
+// Copyright 2018 Canonical Ltd.  All rights reserved.
+
+package core
+
+import (
+	"fmt"
+	"syscall"
+	"time"
+
+	"github.com/CanonicalLtd/flashback/audit"
+)
+
+// Actions that can be taken after a factory reset
+const (
+	ActionReboot   = "reboot"
+	ActionPowerOff = "poweroff"
+	ActionHalt     = "halt"
+	ActionNone     = "none"
+)
+
+var rebootCommands = map[string]int{
+	ActionReboot:   syscall.LINUX_REBOOT_CMD_RESTART,
+	ActionPowerOff: syscall.LINUX_REBOOT_CMD_POWER_OFF,
+	ActionHalt:     syscall.LINUX_REBOOT_CMD_HALT,
+}
+
+// PostResetAction chooses the action after a factory reset: the action of
+// the command line if one is given, otherwise the configured action for the
+// outcome of the reset
+func PostResetAction(resetErr error, action, onFailure, override string) string {
+	if len(override) > 0 {
+		return override
+	}
+	if resetErr != nil {
+		return onFailure
+	}
+	return action
+}
+
+// PowerAction reboots, powers off or halts the device after a delay in
+// seconds. The filesystems are synced first
+func PowerAction(action string, delay int) error {
+	if action == ActionNone || len(action) == 0 {
+		return nil
+	}
+
+	cmd, ok := rebootCommands[action]
+	if !ok {
+		return fmt.Errorf("action `%s` is not implemented", action)
+	}
+
+	if delay > 0 {
+		audit.Printf("Run `%s` in %d seconds\n", action, delay)
+		time.Sleep(time.Duration(delay) * time.Second)
+	}
+
+	audit.Printf("Sync the filesystems and run `%s`\n", action)
+	syscall.Sync()
+	return syscall.Reboot(cmd)
+}
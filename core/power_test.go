@@ -0,0 +1,32 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+// Flashback
+// Copyright 2018 Canonical Ltd.  All rights reserved.
+
+package core_test
+
+import (
+	"errors"
+
+	"github.com/CanonicalLtd/flashback/core"
+	check "gopkg.in/check.v1"
+)
+
+func (s *coreSuite) TestPostResetAction(c *check.C) {
+	failed := errors.New("reset failed")
+	tests := []struct {
+		resetErr error
+		override string
+		action   string
+	}{
+		{nil, "", core.ActionReboot},
+		{failed, "", core.ActionNone},
+		{nil, core.ActionPowerOff, core.ActionPowerOff},
+		{failed, core.ActionHalt, core.ActionHalt},
+		{failed, core.ActionReboot, core.ActionReboot},
+	}
+
+	for _, t := range tests {
+		action := core.PostResetAction(t.resetErr, core.ActionReboot, core.ActionNone, t.override)
+		c.Check(action, check.Equals, t.action, check.Commentf("%v, %s", t.resetErr, t.override))
+	}
+}
@@ -0,0 +1,155 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+// Flashback
+// Copyright 2018 Canonical Ltd.  All rights reserved.
+
+package core
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"github.com/CanonicalLtd/flashback/audit"
+)
+
+const featureReadOnly = "read-only"
+
+// hasReadOnlyFlag checks whether a filesystem type has the read-only feature.
+// Only ext2/3/4 have it
+func hasReadOnlyFlag(fsType string) bool {
+	switch fsType {
+	case "ext2", "ext3", "ext4":
+		return true
+	default:
+		return false
+	}
+}
+
+// IsReadOnly checks whether the read-only feature is set on a filesystem.
+// Filesystems other than ext2/3/4 do not have the feature
+func IsReadOnly(device string) (bool, error) {
+	fsType, err := FSType(device)
+	if err != nil {
+		return false, err
+	}
+	if !hasReadOnlyFlag(fsType) {
+		return false, nil
+	}
+
+	out, err := exec.Command("tune2fs", "-l", device).Output()
+	if err != nil {
+		return false, err
+	}
+
+	for _, line := range strings.Split(string(out), "\n") {
+		if !strings.HasPrefix(line, "Filesystem features:") {
+			continue
+		}
+		for _, f := range strings.Fields(strings.TrimPrefix(line, "Filesystem features:")) {
+			if f == featureReadOnly {
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+	return false, fmt.Errorf("cannot find the features of `%s`", device)
+}
+
+// SetReadOnly sets or clears the read-only feature of a filesystem. The
+// filesystem cannot be mounted read-write while the feature is set
+func SetReadOnly(device string, readonly bool) error {
+	fsType, err := FSType(device)
+	if err != nil {
+		return err
+	}
+	if !hasReadOnlyFlag(fsType) {
+		return fmt.Errorf("read-only flag for `%s` is not implemented", fsType)
+	}
+
+	feature := featureReadOnly
+	if !readonly {
+		feature = "^" + featureReadOnly
+	}
+
+	_ = Unmount(device)
+	out, err := exec.Command("tune2fs", "-O", feature, device).CombinedOutput()
+	if err != nil {
+		audit.Println(string(out))
+	}
+	return err
+}
+
+// ProtectRestore write-protects the restore partition. Filesystems without a
+// read-only flag are left writable with a warning
+func ProtectRestore() error {
+	fsType, err := FSType(PartitionTable.Restore)
+	if err != nil {
+		return err
+	}
+	if !hasReadOnlyFlag(fsType) {
+		audit.Printf("WARNING: the restore partition cannot be write-protected, `%s` does not have a read-only flag\n", fsType)
+		return nil
+	}
+
+	audit.Println("Write-protect the restore partition")
+	return SetReadOnly(PartitionTable.Restore, true)
+}
+
+// UnprotectRestore lifts the write protection of the restore partition, so it
+// can be mounted read-write. Returns whether the partition was protected
+func UnprotectRestore() (bool, error) {
+	readonly, err := IsReadOnly(PartitionTable.Restore)
+	if err != nil || !readonly {
+		return false, err
+	}
+
+	audit.Println("Lift the write protection of the restore partition")
+	return true, SetReadOnly(PartitionTable.Restore, false)
+}
+
+// ChangeRestore mounts the restore partition read-write to make a small
+// change outside of a bootprint. The write protection is lifted for the
+// change and put back if it was set
+func ChangeRestore(change func() error) error {
+	protected, err := UnprotectRestore()
+	if err != nil {
+		audit.Println("Cannot lift the write protection of the restore partition:", err)
+	}
+	if protected {
+		defer func() {
+			if err := ProtectRestore(); err != nil {
+				audit.Println("Cannot write-protect the restore partition:", err)
+			}
+		}()
+	}
+
+	if err := Mount(PartitionTable.Restore, RestorePath); err != nil {
+		return err
+	}
+	defer Unmount(RestorePath)
+
+	return change()
+}
+
+// CheckRestoreProtected warns if the write protection of the restore partition
+// has been removed. Filesystems without a read-only flag are not checked, as
+// they were never protected
+func CheckRestoreProtected() {
+	fsType, err := FSType(PartitionTable.Restore)
+	if err != nil {
+		audit.Println("Cannot check the write protection of the restore partition:", err)
+		return
+	}
+	if !hasReadOnlyFlag(fsType) {
+		return
+	}
+
+	readonly, err := IsReadOnly(PartitionTable.Restore)
+	if err != nil {
+		audit.Println("Cannot check the write protection of the restore partition:", err)
+		return
+	}
+	if !readonly {
+		audit.Println("WARNING: the restore partition is not write-protected, the recovery image may have been changed")
+	}
+}
@@ -0,0 +1,79 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+// Flashback
+// Copyright 2018 Canonical Ltd.  All rights reserved.
+
+package core_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/CanonicalLtd/flashback/core"
+	check "gopkg.in/check.v1"
+)
+
+func (s *coreSuite) TestHasReadOnlyFlag(c *check.C) {
+	tests := []struct {
+		fsType string
+		flag   bool
+	}{
+		{"ext2", true},
+		{"ext3", true},
+		{"ext4", true},
+		{"vfat", false},
+		{"btrfs", false},
+		{"exfat", false},
+		{"", false},
+	}
+
+	for _, t := range tests {
+		c.Check(core.HasReadOnlyFlag(t.fsType), check.Equals, t.flag, check.Commentf(t.fsType))
+	}
+}
+
+// mockTools puts scripts for blkid and tune2fs first on the path. tune2fs
+// fails if it is called for a filesystem without the read-only feature
+func mockTools(c *check.C, fsType, features string) (restore func()) {
+	dir := c.MkDir()
+	scripts := map[string]string{
+		"blkid":   fmt.Sprintf("#!/bin/sh\necho %s\n", fsType),
+		"tune2fs": fmt.Sprintf("#!/bin/sh\necho 'Filesystem features: %s'\n", features),
+	}
+	if len(features) == 0 {
+		scripts["tune2fs"] = "#!/bin/sh\nexit 1\n"
+	}
+	for name, script := range scripts {
+		c.Assert(ioutil.WriteFile(filepath.Join(dir, name), []byte(script), 0755), check.IsNil)
+	}
+
+	path := os.Getenv("PATH")
+	os.Setenv("PATH", dir+":"+path)
+	return func() { os.Setenv("PATH", path) }
+}
+
+func (s *coreSuite) TestIsReadOnly(c *check.C) {
+	tests := []struct {
+		fsType   string
+		features string
+		readonly bool
+	}{
+		{"ext4", "has_journal extent read-only", true},
+		{"ext4", "has_journal extent", false},
+		{"vfat", "", false},
+	}
+
+	for _, t := range tests {
+		restore := mockTools(c, t.fsType, t.features)
+		readonly, err := core.IsReadOnly("/dev/restore")
+		restore()
+		c.Assert(err, check.IsNil, check.Commentf(t.fsType))
+		c.Check(readonly, check.Equals, t.readonly, check.Commentf("%s: %s", t.fsType, t.features))
+	}
+
+	// The flag cannot be set on a filesystem without it
+	restore := mockTools(c, "vfat", "")
+	defer restore()
+	c.Assert(core.SetReadOnly("/dev/restore", true), check.ErrorMatches, "read-only flag for `vfat` is not implemented")
+}
@@ -0,0 +1,75 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+// Flashback
+// Copyright 2018 Canonical Ltd.  All rights reserved.
+
+package core
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/CanonicalLtd/flashback/audit"
+	"github.com/CanonicalLtd/flashback/bootenv"
+	"github.com/CanonicalLtd/flashback/config"
+)
+
+// Slot is a boot slot of an A/B system-boot
+type Slot struct {
+	Name   string
+	Device string
+}
+
+// Image is the path of the image of the slot on the restore partition e.g.
+// system-boot-a.img.gz
+func (s Slot) Image() string {
+	base := strings.TrimSuffix(BackupImageSystemBoot, ".img.gz")
+	return fmt.Sprintf("%s-%s.img.gz", base, s.Name)
+}
+
+// Slot finds a boot slot by name
+func (p Partition) Slot(name string) (Slot, bool) {
+	for _, s := range p.Slots {
+		if s.Name == name {
+			return s, true
+		}
+	}
+	return Slot{}, false
+}
+
+// findSlots locates the partitions of the boot slots
+func findSlots() ([]Slot, error) {
+	slots := []Slot{}
+	for _, s := range config.Store.Slots.Partitions {
+		device, err := findPartition(PartitionSystemBoot+"-"+s.Name, s.Partition)
+		if err != nil {
+			return nil, err
+		}
+		slots = append(slots, Slot{Name: s.Name, Device: device})
+	}
+	return slots, nil
+}
+
+// ActiveSlot reads the slot selector from the boot environment
+func ActiveSlot() (Slot, error) {
+	var name string
+	err := WithBootEnv(false, ConfiguredEnv, func(env bootenv.Env) {
+		name = env.Get(config.Store.Slots.Selector)
+	})
+	if err != nil {
+		return Slot{}, err
+	}
+
+	s, ok := PartitionTable.Slot(name)
+	if !ok {
+		return Slot{}, fmt.Errorf("the slot selector `%s` is `%s`, which is not a slot", config.Store.Slots.Selector, name)
+	}
+	return s, nil
+}
+
+// SelectSlot sets the slot selector in the boot environment
+func SelectSlot(name string) error {
+	audit.Printf("Set the slot selector `%s` to `%s`\n", config.Store.Slots.Selector, name)
+	return WithBootEnv(true, ConfiguredEnv, func(env bootenv.Env) {
+		env.Set(config.Store.Slots.Selector, name)
+	})
+}
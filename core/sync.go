@@ -0,0 +1,55 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+// Flashback
+// Copyright 2018 Canonical Ltd.  All rights reserved.
+
+package core
+
+import (
+	"os"
+	"syscall"
+
+	"github.com/CanonicalLtd/flashback/audit"
+)
+
+// blkFlsBuf is the BLKFLSBUF ioctl, which flushes the buffer cache of a block device
+const blkFlsBuf = 0x1261
+
+// FlushDevice flushes the writes to a block device and its buffer cache
+func FlushDevice(device string) error {
+	f, err := os.OpenFile(device, os.O_RDONLY, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if err := f.Sync(); err != nil {
+		return err
+	}
+
+	// Only block devices have a buffer cache
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if info.Mode()&os.ModeDevice == 0 || info.Mode()&os.ModeCharDevice != 0 {
+		return nil
+	}
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), blkFlsBuf, 0); errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// SyncDevices flushes all the filesystems, then the devices
+func SyncDevices(devices ...string) error {
+	audit.Println("Flush the writes to the disk")
+	syscall.Sync()
+
+	for _, device := range devices {
+		if err := FlushDevice(device); err != nil {
+			audit.Printf("Error flushing `%s`: %v\n", device, err)
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,45 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+// Flashback
+// Copyright 2018 Canonical Ltd.  All rights reserved.
+
+package core_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+
+	"github.com/CanonicalLtd/flashback/core"
+	check "gopkg.in/check.v1"
+)
+
+func (s *coreSuite) TestUnzipToDevice(c *check.C) {
+	dir := c.MkDir()
+
+	// The tail of the image does not fill the write buffer
+	content := bytes.Repeat([]byte("system-boot "), 10000)
+	content = append(content, []byte("tail")...)
+	image := filepath.Join(dir, "image")
+	c.Assert(ioutil.WriteFile(image, content, 0644), check.IsNil)
+
+	archive := filepath.Join(dir, "system-boot.img.gz")
+	backup, err := core.ReadAndGzipToFile(image, archive)
+	c.Assert(err, check.IsNil)
+
+	device := filepath.Join(dir, "device")
+	written, err := core.UnzipToDevice(archive, device)
+	c.Assert(err, check.IsNil)
+	c.Assert(written, check.Equals, backup)
+	c.Assert(written.Size, check.Equals, int64(len(content)))
+
+	dat, err := ioutil.ReadFile(device)
+	c.Assert(err, check.IsNil)
+	c.Assert(bytes.Equal(dat, content), check.Equals, true)
+
+	c.Assert(core.FlushDevice(device), check.IsNil)
+
+	// The device is read back up to the size of the image
+	sum, err := core.HashDevice(device, written.Size)
+	c.Assert(err, check.IsNil)
+	c.Assert(sum, check.Equals, written.SHA256)
+}
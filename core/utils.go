@@ -165,6 +165,18 @@ func familyFlag(flag, family string) (string, error) {
 			return "", fmt.Errorf("`sectorsize` for family `%s` is not implemented", family)
 		}
 
+	case "uuid":
+		switch family {
+		case "ext":
+			return "-U", nil
+		case "fat":
+			return "-i", nil
+		case "swap":
+			return "--uuid", nil
+		default:
+			return "", fmt.Errorf("`uuid` for family `%s` is not implemented", family)
+		}
+
 	case "label":
 		switch family {
 		case "ext":
@@ -181,3 +193,12 @@ func familyFlag(flag, family string) (string, error) {
 		return "", fmt.Errorf("flag `%s` is not implemented", flag)
 	}
 }
+
+// familyUUID converts a filesystem UUID to the form that mkfs takes. The
+// volume ID of a FAT filesystem e.g. ABCD-1234 is given without the dash
+func familyUUID(uuid, family string) string {
+	if family == "fat" {
+		return strings.Replace(uuid, "-", "", -1)
+	}
+	return uuid
+}
@@ -66,3 +66,26 @@ func (s *coreSuite) TestUtils(c *check.C) {
 		c.Assert(s, check.Equals, t.Output)
 	}
 }
+
+func (s *coreSuite) TestFamilyUUID(c *check.C) {
+	tests := []struct {
+		family string
+		uuid   string
+		flag   string
+		value  string
+	}{
+		{"ext", "2a6c9d7e-0f5b-4b4c-9b7a-5a3c1d4e8f10", "-U", "2a6c9d7e-0f5b-4b4c-9b7a-5a3c1d4e8f10"},
+		{"fat", "ABCD-1234", "-i", "ABCD1234"},
+		{"swap", "2a6c9d7e-0f5b-4b4c-9b7a-5a3c1d4e8f10", "--uuid", "2a6c9d7e-0f5b-4b4c-9b7a-5a3c1d4e8f10"},
+	}
+
+	for _, t := range tests {
+		flag, err := core.FamilyFlag("uuid", t.family)
+		c.Assert(err, check.IsNil, check.Commentf(t.family))
+		c.Check(flag, check.Equals, t.flag, check.Commentf(t.family))
+		c.Check(core.FamilyUUID(t.uuid, t.family), check.Equals, t.value, check.Commentf(t.family))
+	}
+
+	_, err := core.FamilyFlag("uuid", "btrfs")
+	c.Assert(err, check.ErrorMatches, "`uuid` for family `btrfs` is not implemented")
+}
@@ -0,0 +1,88 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+// Flashback
+// Copyright 2018 Canonical Ltd.  All rights reserved.
+
+package core
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+)
+
+// ValidateGzip reads a gzip file end-to-end, checking the CRC and size of
+// the content. Returns the size of the uncompressed content
+func ValidateGzip(path string) (int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	n, err := CheckGzip(f)
+	if err != nil {
+		return n, fmt.Errorf("`%s`: %v", path, err)
+	}
+	return n, nil
+}
+
+// ValidateTarGz reads a gzipped tar file end-to-end, checking the CRC of the
+// compressed data and the structure of the archive. Returns the number of entries
+func ValidateTarGz(path string) (int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	count, err := CheckTarGz(f)
+	if err != nil {
+		return count, fmt.Errorf("`%s`: %v", path, err)
+	}
+	return count, nil
+}
+
+// CheckGzip reads a gzip stream end-to-end, like ValidateGzip
+func CheckGzip(r io.Reader) (int64, error) {
+	gr, err := gzip.NewReader(r)
+	if err != nil {
+		return 0, err
+	}
+	defer gr.Close()
+
+	return io.Copy(ioutil.Discard, gr)
+}
+
+// CheckTarGz reads a gzipped tar stream end-to-end, like ValidateTarGz
+func CheckTarGz(r io.Reader) (int, error) {
+	gr, err := gzip.NewReader(r)
+	if err != nil {
+		return 0, err
+	}
+	defer gr.Close()
+
+	tr := tar.NewReader(gr)
+	count := 0
+	for {
+		_, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return count, fmt.Errorf("entry %d: %v", count+1, err)
+		}
+		if _, err := io.Copy(ioutil.Discard, tr); err != nil {
+			return count, fmt.Errorf("entry %d: %v", count+1, err)
+		}
+		count++
+	}
+
+	// Read to the end of the gzip stream, so the CRC is checked
+	if _, err := io.Copy(ioutil.Discard, gr); err != nil {
+		return count, err
+	}
+	return count, nil
+}
@@ -0,0 +1,67 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+// Flashback
+// Copyright 2018 Canonical Ltd.  All rights reserved.
+
+package core_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"path/filepath"
+
+	"github.com/CanonicalLtd/flashback/core"
+	check "gopkg.in/check.v1"
+)
+
+// tarGz creates a gzipped tar archive with a file
+func tarGz(c *check.C) []byte {
+	buf := bytes.Buffer{}
+	gw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gw)
+
+	content := bytes.Repeat([]byte("flashback "), 1000)
+	err := tw.WriteHeader(&tar.Header{Name: "system-data/file", Mode: 0644, Size: int64(len(content)), Typeflag: tar.TypeReg})
+	c.Assert(err, check.IsNil)
+	_, err = tw.Write(content)
+	c.Assert(err, check.IsNil)
+
+	c.Assert(tw.Close(), check.IsNil)
+	c.Assert(gw.Close(), check.IsNil)
+	return buf.Bytes()
+}
+
+func (s *coreSuite) TestValidateTarGz(c *check.C) {
+	dir := c.MkDir()
+	valid := tarGz(c)
+
+	corrupt := append([]byte{}, valid...)
+	corrupt[len(corrupt)-6] ^= 0xff
+
+	tests := []struct {
+		name  string
+		data  []byte
+		valid bool
+	}{
+		{"valid.tar.gz", valid, true},
+		{"truncated.tar.gz", valid[:len(valid)/2], false},
+		{"corrupt.tar.gz", corrupt, false},
+		{"empty.tar.gz", []byte{}, false},
+	}
+
+	for _, t := range tests {
+		path := filepath.Join(dir, t.name)
+		c.Assert(ioutil.WriteFile(path, t.data, 0644), check.IsNil)
+
+		_, errTar := core.ValidateTarGz(path)
+		_, errGzip := core.ValidateGzip(path)
+		if t.valid {
+			c.Assert(errTar, check.IsNil, check.Commentf(t.name))
+			c.Assert(errGzip, check.IsNil, check.Commentf(t.name))
+		} else {
+			c.Assert(errTar, check.NotNil, check.Commentf(t.name))
+			c.Assert(errGzip, check.NotNil, check.Commentf(t.name))
+		}
+	}
+}
@@ -4,13 +4,156 @@
 
 package execute
 
+import (
+	"fmt"
+	"strings"
+)
+
+// Names of the commands
+const (
+	CommandBootprint = "bootprint"
+	CommandReset     = "reset"
+	CommandAuto      = "auto"
+	CommandVerify    = "verify"
+	CommandStatus    = "status"
+	CommandValidate  = "validate"
+	CommandConfig    = "config"
+	CommandExport    = "export"
+	CommandImport    = "import"
+	CommandHistory   = "history"
+
+	// CommandNone only reads the config, when no command or option is given
+	CommandNone = ""
+)
+
 // Command defines the execution options for the application
 type Command struct {
-	ConfigPath   string `short:"c" long:"config" description:"read configuration from cfg"`
-	FactoryReset bool   `long:"factory-reset" description:"run a factory reset of the device"`
-	Bootprint    bool   `long:"bootprint" description:"create a recovery image for the device"`
-	Check        bool   `long:"check" description:"check that a recovery image does not exist (used with the --bootprint option)"`
+	ConfigPath string `short:"c" long:"config" description:"read configuration from cfg"`
+
+	// Overrides for the paths in the config file
+	RestoreMount    string `long:"restore-mount" description:"mount point of the restore partition"`
+	WritableMount   string `long:"writable-mount" description:"mount point of the writable partition"`
+	TmpfsMount      string `long:"tmpfs-mount" description:"mount point of the RAM disk for the retained data"`
+	SystemBootMount string `long:"system-boot-mount" description:"mount point of the system-boot partition"`
+	WritableArchive string `long:"writable-archive" description:"backup file of writable (relative to the restore partition)"`
+	SystemBootImage string `long:"system-boot-image" description:"backup image of system-boot (relative to the restore partition)"`
+	LogFile         string `long:"log" description:"write the log to this file"`
+	BootprintLog    string `long:"bootprint-log" description:"keep the log of a failed bootprint in this file"`
+	ResetLog        string `long:"reset-log" description:"keep the log of a failed factory reset in this file"`
+
+	// Aliases of the commands, kept for existing initramfs scripts
+	FactoryReset bool `long:"factory-reset" description:"run a factory reset of the device (same as the reset command)"`
+	Bootprint    bool `long:"bootprint" description:"create a recovery image for the device (same as the bootprint command)"`
+	Check        bool `long:"check" description:"check that a recovery image does not exist (used with the --bootprint option)"`
+	Auto         bool `long:"auto" description:"run a factory reset if one is requested, otherwise create the recovery image if it does not exist (same as the auto command)"`
+
+	BootprintCmd BootprintCommand   `command:"bootprint" description:"create a recovery image for the device"`
+	Reset        ResetCommand       `command:"reset" description:"run a factory reset of the device"`
+	AutoCmd      AutoCommand        `command:"auto" description:"run a factory reset if one is requested, otherwise create the recovery image if it does not exist"`
+	Verify       VerifyCommand      `command:"verify" description:"check that the recovery image is complete and not corrupt"`
+	Status       StatusCommand      `command:"status" description:"show the recovery image, reset requests and the last factory reset"`
+	Validate     ValidateCommand    `command:"validate" description:"check the config file for errors"`
+	PrintConfig  PrintConfigCommand `command:"config" description:"print the effective config, merged with the drop-in files and the environment"`
+	Export       ExportCommand      `command:"export" description:"pack the recovery image into a bundle file"`
+	Import       ImportCommand      `command:"import" description:"write the recovery image from a bundle file to the restore partition"`
+	History      HistoryCommand     `command:"history" description:"show the bootprints and factory resets of the device"`
+}
+
+// BootprintCommand creates the recovery image
+type BootprintCommand struct {
+	Check bool `long:"check" description:"only create the recovery image if it does not exist"`
+	Delta bool `long:"delta" description:"save the changes to writable since the full archive as a new generation"`
+}
+
+// ResetCommand runs a factory reset
+type ResetCommand struct {
+	PostReset  string `long:"post-reset" choice:"reboot" choice:"poweroff" choice:"halt" choice:"none" description:"action after the factory reset, instead of the configured one"`
+	Generation int    `long:"generation" default:"-1" description:"generation of the recovery image to restore, 0 for the full archive (default: the latest)"`
+	ReadBack   bool   `long:"read-back" description:"read back the restored partitions and compare them with the recovery image"`
+}
+
+// AutoCommand runs a factory reset or creates the recovery image
+type AutoCommand struct {
+	PostReset string `long:"post-reset" choice:"reboot" choice:"poweroff" choice:"halt" choice:"none" description:"action after the factory reset, instead of the configured one"`
+}
+
+// VerifyCommand checks the recovery image
+type VerifyCommand struct{}
+
+// StatusCommand shows the state of the recovery image
+type StatusCommand struct{}
+
+// ValidateCommand checks the config file given by the --config option
+type ValidateCommand struct{}
+
+// PrintConfigCommand prints the effective config
+type PrintConfigCommand struct{}
+
+// ExportCommand packs the recovery image into a bundle
+type ExportCommand struct {
+	Output string `short:"o" long:"output" required:"true" description:"write the bundle to this file"`
+}
+
+// ImportCommand provisions the restore partition from a bundle
+type ImportCommand struct {
+	Input string `short:"i" long:"input" required:"true" description:"read the bundle from this file"`
+}
+
+// HistoryCommand shows the history on the restore partition
+type HistoryCommand struct {
+	JSON bool `long:"json" description:"print the entries as JSON, one per line"`
 }
 
 // Execution is the implementation of the execution options
 var Execution Command
+
+// Resolve works out the command to run from the active subcommand or the
+// old options. Only one command can be run, and without one only the config
+// is read, as with the old options
+func (c *Command) Resolve(active string) (string, error) {
+	aliases := []string{}
+	if c.Bootprint {
+		aliases = append(aliases, "--bootprint")
+	}
+	if c.FactoryReset {
+		aliases = append(aliases, "--factory-reset")
+	}
+	if c.Auto {
+		aliases = append(aliases, "--auto")
+	}
+
+	switch {
+	case c.Check && !c.Bootprint && active != CommandBootprint:
+		return "", fmt.Errorf("--check is only used with --bootprint")
+	case c.CheckRecovery() && c.BootprintCmd.Delta:
+		return "", fmt.Errorf("--check cannot be used with --delta")
+	case len(aliases) > 1:
+		return "", fmt.Errorf("%s cannot be used together", strings.Join(aliases, " and "))
+	case len(aliases) > 0 && len(active) > 0:
+		return "", fmt.Errorf("%s cannot be used with the %s command", aliases[0], active)
+	case len(active) > 0:
+		return active, nil
+	case c.Bootprint:
+		return CommandBootprint, nil
+	case c.FactoryReset:
+		return CommandReset, nil
+	case c.Auto:
+		return CommandAuto, nil
+	default:
+		// As before the commands, only the config is read
+		return CommandNone, nil
+	}
+}
+
+// CheckRecovery is whether the bootprint only creates a recovery image that does not exist
+func (c *Command) CheckRecovery() bool {
+	return c.Check || c.BootprintCmd.Check
+}
+
+// PostResetAction is the action after a factory reset from the command line, if one is set
+func (c *Command) PostResetAction() string {
+	if len(c.Reset.PostReset) > 0 {
+		return c.Reset.PostReset
+	}
+	return c.AutoCmd.PostReset
+}
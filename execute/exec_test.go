@@ -0,0 +1,94 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+// Flashback
+// Copyright 2018 Canonical Ltd.  All rights reserved.
+
+package execute_test
+
+import (
+	"testing"
+
+	"github.com/CanonicalLtd/flashback/execute"
+	flags "github.com/jessevdk/go-flags"
+	check "gopkg.in/check.v1"
+)
+
+type executeSuite struct{}
+
+var _ = check.Suite(&executeSuite{})
+
+func TestExecute(t *testing.T) { check.TestingT(t) }
+
+// resolve parses the arguments as the command line and works out the command
+func resolve(args []string) (execute.Command, string, error) {
+	cmd := execute.Command{}
+	parser := flags.NewParser(&cmd, flags.Default&^flags.PrintErrors)
+	parser.SubcommandsOptional = true
+
+	_, err := parser.ParseArgs(args)
+	if err != nil {
+		return cmd, "", err
+	}
+
+	active := ""
+	if parser.Active != nil {
+		active = parser.Active.Name
+	}
+	command, err := cmd.Resolve(active)
+	return cmd, command, err
+}
+
+func (s *executeSuite) TestResolve(c *check.C) {
+	tests := []struct {
+		args    []string
+		command string
+		check   bool
+		success bool
+	}{
+		{[]string{"--config=x"}, execute.CommandNone, false, true},
+		{[]string{}, execute.CommandNone, false, true},
+		{[]string{"--config=x", "--bootprint"}, execute.CommandBootprint, false, true},
+		{[]string{"--config=x", "--bootprint", "--check"}, execute.CommandBootprint, true, true},
+		{[]string{"--config=x", "--factory-reset"}, execute.CommandReset, false, true},
+		{[]string{"--config=x", "--auto"}, execute.CommandAuto, false, true},
+		{[]string{"--config=x", "bootprint", "--delta"}, execute.CommandBootprint, false, true},
+		{[]string{"--config=x", "bootprint", "--check"}, execute.CommandBootprint, true, true},
+		{[]string{"--config=x", "--check", "bootprint"}, execute.CommandBootprint, true, true},
+		{[]string{"--config=x", "status"}, execute.CommandStatus, false, true},
+		{[]string{"--config=x", "--check"}, "", false, false},
+		{[]string{"--config=x", "--check", "reset"}, "", false, false},
+		{[]string{"--config=x", "--bootprint", "--factory-reset"}, "", false, false},
+		{[]string{"--config=x", "--auto", "--factory-reset"}, "", false, false},
+		{[]string{"--config=x", "--bootprint", "reset"}, "", false, false},
+		{[]string{"--config=x", "--auto", "auto"}, "", false, false},
+		{[]string{"--config=x", "bootprint", "--check", "--delta"}, "", false, false},
+	}
+
+	for _, t := range tests {
+		cmd, command, err := resolve(t.args)
+		if !t.success {
+			c.Check(err, check.NotNil, check.Commentf("%v", t.args))
+			continue
+		}
+		c.Assert(err, check.IsNil, check.Commentf("%v", t.args))
+		c.Check(command, check.Equals, t.command, check.Commentf("%v", t.args))
+		c.Check(cmd.CheckRecovery(), check.Equals, t.check, check.Commentf("%v", t.args))
+	}
+}
+
+func (s *executeSuite) TestPostResetAction(c *check.C) {
+	tests := []struct {
+		args   []string
+		action string
+	}{
+		{[]string{"reset"}, ""},
+		{[]string{"reset", "--post-reset=poweroff"}, "poweroff"},
+		{[]string{"auto", "--post-reset=none"}, "none"},
+		{[]string{"--auto"}, ""},
+	}
+
+	for _, t := range tests {
+		cmd, _, err := resolve(t.args)
+		c.Assert(err, check.IsNil, check.Commentf("%v", t.args))
+		c.Check(cmd.PostResetAction(), check.Equals, t.action, check.Commentf("%v", t.args))
+	}
+}
@@ -0,0 +1,107 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+// Flashback
+// Copyright 2018 Canonical Ltd.  All rights reserved.
+
+package history
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Outcomes of a run in the history
+const (
+	OutcomeSuccess = "success"
+	OutcomeFailed  = "failed"
+)
+
+// Entry records a bootprint or a factory reset. The generation is the one
+// that was created by a bootprint, or restored by a factory reset, and 0
+// is the full writable archive
+type Entry struct {
+	Time       string   `json:"time"`
+	Command    string   `json:"command"`
+	Trigger    string   `json:"trigger,omitempty"`
+	Generation int      `json:"generation"`
+	Retained   []string `json:"retained,omitempty"`
+	Outcome    string   `json:"outcome"`
+	Error      string   `json:"error,omitempty"`
+}
+
+// New creates an entry for the outcome of a command
+func New(command string, when time.Time, runErr error) Entry {
+	e := Entry{
+		Time:    when.UTC().Format(time.RFC3339),
+		Command: command,
+		Outcome: OutcomeSuccess,
+	}
+	if runErr != nil {
+		e.Outcome = OutcomeFailed
+		e.Error = runErr.Error()
+	}
+	return e
+}
+
+// Append adds an entry to the end of the history file, one JSON object per
+// line. Earlier entries are never rewritten
+func Append(path string, e Entry) error {
+	dat, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+
+	// Start on a new line if the last entry was cut short
+	line := append(dat, '\n')
+	if info, err := f.Stat(); err == nil && info.Size() > 0 {
+		last := make([]byte, 1)
+		if _, err := f.ReadAt(last, info.Size()-1); err == nil && last[0] != '\n' {
+			line = append([]byte{'\n'}, line...)
+		}
+	}
+
+	_, err = f.Write(line)
+	if err == nil {
+		err = f.Sync()
+	}
+	if errClose := f.Close(); err == nil {
+		err = errClose
+	}
+	return err
+}
+
+// Read reads the entries of the history file, oldest first. A line that
+// cannot be read e.g. one cut short by a power cut, is skipped
+func Read(path string) ([]Entry, int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer f.Close()
+
+	entries := []Entry{}
+	skipped := 0
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		e := Entry{}
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			skipped++
+			continue
+		}
+		entries = append(entries, e)
+	}
+	return entries, skipped, scanner.Err()
+}
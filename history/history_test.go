@@ -0,0 +1,58 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+// Flashback
+// Copyright 2018 Canonical Ltd.  All rights reserved.
+
+package history_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/CanonicalLtd/flashback/history"
+	check "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { check.TestingT(t) }
+
+type historySuite struct{}
+
+var _ = check.Suite(&historySuite{})
+
+func (s *historySuite) TestAppend(c *check.C) {
+	path := filepath.Join(c.MkDir(), "history.jsonl")
+	when := time.Date(2018, 9, 27, 10, 0, 0, 0, time.UTC)
+
+	bootprint := history.New("bootprint", when, nil)
+	bootprint.Trigger = "auto"
+	c.Assert(history.Append(path, bootprint), check.IsNil)
+
+	// An entry cut short by a power cut
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	c.Assert(err, check.IsNil)
+	_, err = f.WriteString(`{"time":"2018-09-28T`)
+	c.Assert(err, check.IsNil)
+	c.Assert(f.Close(), check.IsNil)
+
+	reset := history.New("reset", when.Add(24*time.Hour), errors.New("cannot format writable"))
+	reset.Generation = 2
+	reset.Retained = []string{"/var/log/boot.log"}
+	c.Assert(history.Append(path, reset), check.IsNil)
+
+	entries, skipped, err := history.Read(path)
+	c.Assert(err, check.IsNil)
+	c.Assert(skipped, check.Equals, 1)
+	c.Assert(entries, check.HasLen, 2)
+	c.Assert(entries[0], check.DeepEquals, history.Entry{
+		Time:    "2018-09-27T10:00:00Z",
+		Command: "bootprint",
+		Trigger: "auto",
+		Outcome: history.OutcomeSuccess,
+	})
+	c.Assert(entries[1].Outcome, check.Equals, history.OutcomeFailed)
+	c.Assert(entries[1].Error, check.Equals, "cannot format writable")
+	c.Assert(entries[1].Generation, check.Equals, 2)
+	c.Assert(entries[1].Retained, check.DeepEquals, []string{"/var/log/boot.log"})
+}
@@ -0,0 +1,170 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+// Flashback
+// Copyright 2018 Canonical Ltd.  All rights reserved.
+
+package manifest
+
+import (
+	"io/ioutil"
+	"os"
+	"time"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// Version of the manifest format
+const Version = 1
+
+// Manifest describes the recovery image on the restore partition. The
+// generations are deltas of the writable archive, oldest first. The slots
+// are the images of the A/B boot slots, by slot name
+type Manifest struct {
+	Version      int               `yaml:"version"`
+	Created      string            `yaml:"created"`
+	SystemBoot   *Image            `yaml:"system-boot,omitempty"`
+	WritableSums string            `yaml:"writable-sums,omitempty"`
+	ActiveSlot   string            `yaml:"active-slot,omitempty"`
+	Slots        map[string]*Image `yaml:"slots,omitempty"`
+	Generations  []Generation      `yaml:"generations,omitempty"`
+}
+
+// Image is the size and SHA-256 checksum of the content of a raw partition image
+type Image struct {
+	Size   int64  `yaml:"size"`
+	SHA256 string `yaml:"sha256"`
+}
+
+// Generation describes a delta of the writable archive against the full
+// archive, and the checksums of the files in the delta
+type Generation struct {
+	Number  int    `yaml:"number"`
+	Archive string `yaml:"archive"`
+	Sums    string `yaml:"sums,omitempty"`
+	Created string `yaml:"created"`
+}
+
+// New creates a manifest for a recovery image
+func New(created time.Time) *Manifest {
+	return &Manifest{
+		Version: Version,
+		Created: created.UTC().Format(time.RFC3339),
+	}
+}
+
+// Read parses the manifest file
+func Read(path string) (*Manifest, error) {
+	dat, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(dat)
+}
+
+// Parse parses the content of a manifest file
+func Parse(dat []byte) (*Manifest, error) {
+	m := &Manifest{}
+	err := yaml.Unmarshal(dat, m)
+	return m, err
+}
+
+// Write saves the manifest file and flushes it to the disk
+func (m *Manifest) Write(path string) error {
+	dat, err := yaml.Marshal(m)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(dat); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// CreatedTime returns the time the recovery image was created
+func (m *Manifest) CreatedTime() (time.Time, error) {
+	return time.Parse(time.RFC3339, m.Created)
+}
+
+// Next returns the number of the next generation
+func (m *Manifest) Next() int {
+	if latest := m.Latest(); latest != nil {
+		return latest.Number + 1
+	}
+	return 1
+}
+
+// AddGeneration records a new delta
+func (m *Manifest) AddGeneration(number int, archive, sums string, created time.Time) {
+	m.Generations = append(m.Generations, Generation{
+		Number:  number,
+		Archive: archive,
+		Sums:    sums,
+		Created: created.UTC().Format(time.RFC3339),
+	})
+}
+
+// Latest returns the newest generation, or nil when there are only the full archives
+func (m *Manifest) Latest() *Generation {
+	if len(m.Generations) == 0 {
+		return nil
+	}
+	return &m.Generations[len(m.Generations)-1]
+}
+
+// Generation finds a generation by its number
+func (m *Manifest) Generation(number int) *Generation {
+	for i := range m.Generations {
+		if m.Generations[i].Number == number {
+			return &m.Generations[i]
+		}
+	}
+	return nil
+}
+
+// Prune removes the oldest generations, so no more than `keep` remain.
+// Returns the removed generations
+func (m *Manifest) Prune(keep int) []Generation {
+	if len(m.Generations) <= keep {
+		return nil
+	}
+	n := len(m.Generations) - keep
+	removed := append([]Generation{}, m.Generations[:n]...)
+	m.Generations = append([]Generation{}, m.Generations[n:]...)
+	return removed
+}
+
+// CreatedTime returns the time the generation was created
+func (g *Generation) CreatedTime() (time.Time, error) {
+	return time.Parse(time.RFC3339, g.Created)
+}
+
+// ImageTime returns the time the recovery image was created, or its latest
+// generation. Images created
+// without a manifest use the modification time of the archive instead
+func ImageTime(path, archive string) (time.Time, error) {
+	m, err := Read(path)
+	if err == nil {
+		if g := m.Latest(); g != nil {
+			return g.CreatedTime()
+		}
+		return m.CreatedTime()
+	}
+	if !os.IsNotExist(err) {
+		return time.Time{}, err
+	}
+
+	info, err := os.Stat(archive)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return info.ModTime(), nil
+}
@@ -0,0 +1,46 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+// Flashback
+// Copyright 2018 Canonical Ltd.  All rights reserved.
+
+package manifest_test
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/CanonicalLtd/flashback/manifest"
+	check "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { check.TestingT(t) }
+
+type manifestSuite struct{}
+
+var _ = check.Suite(&manifestSuite{})
+
+func (s *manifestSuite) TestGenerations(c *check.C) {
+	created := time.Date(2018, 6, 1, 10, 0, 0, 0, time.UTC)
+	m := manifest.New(created)
+	c.Assert(m.Latest(), check.IsNil)
+
+	for i := 1; i <= 4; i++ {
+		c.Assert(m.Next(), check.Equals, i)
+		m.AddGeneration(i, "writable.delta.tar.gz", "", created.Add(time.Duration(i)*time.Hour))
+	}
+
+	removed := m.Prune(2)
+	c.Assert(removed, check.HasLen, 2)
+	c.Assert(removed[0].Number, check.Equals, 1)
+	c.Assert(m.Generations, check.HasLen, 2)
+	c.Assert(m.Generation(1), check.IsNil)
+	c.Assert(m.Generation(3), check.NotNil)
+	c.Assert(m.Next(), check.Equals, 5)
+
+	// The image time is the time of the latest generation
+	path := filepath.Join(c.MkDir(), "manifest.yaml")
+	c.Assert(m.Write(path), check.IsNil)
+	when, err := manifest.ImageTime(path, "")
+	c.Assert(err, check.IsNil)
+	c.Assert(when.Equal(created.Add(4*time.Hour)), check.Equals, true)
+}
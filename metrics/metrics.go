@@ -0,0 +1,232 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+// Flashback
+// Copyright 2018 Canonical Ltd.  All rights reserved.
+
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Names of the metrics in the textfile
+const (
+	RunsTotal          = "flashback_runs_total"
+	LastRunTimestamp   = "flashback_last_run_timestamp_seconds"
+	LastRunSuccess     = "flashback_last_run_success"
+	PhaseDuration      = "flashback_phase_duration_seconds"
+	RecoveryImageBytes = "flashback_recovery_image_bytes"
+	RetainedBytes      = "flashback_retained_bytes"
+)
+
+// Results of a run, the `result` label of the runs counter
+const (
+	ResultSuccess = "success"
+	ResultFailed  = "failed"
+)
+
+// description is the type and help text of a metric
+type description struct {
+	kind string
+	help string
+}
+
+var descriptions = map[string]description{
+	RunsTotal:          {"counter", "Runs of flashback by command and result."},
+	LastRunTimestamp:   {"gauge", "Time of the last run of flashback by command."},
+	LastRunSuccess:     {"gauge", "Whether the last run of flashback by command succeeded."},
+	PhaseDuration:      {"gauge", "Duration of each phase of the last run of flashback by command."},
+	RecoveryImageBytes: {"gauge", "Size of the files on the restore partition."},
+	RetainedBytes:      {"gauge", "Size of the data kept over the last factory reset."},
+}
+
+// Set is the samples of the metrics, by series e.g. `flashback_runs_total{command="reset",result="success"}`
+type Set map[string]float64
+
+// series formats the name and the label pairs of a sample
+func series(name string, labels ...string) string {
+	if len(labels) == 0 {
+		return name
+	}
+	pairs := []string{}
+	for i := 0; i+1 < len(labels); i += 2 {
+		pairs = append(pairs, fmt.Sprintf("%s=%q", labels[i], labels[i+1]))
+	}
+	return name + "{" + strings.Join(pairs, ",") + "}"
+}
+
+// metricName returns the name of the metric of a series
+func metricName(s string) string {
+	return strings.SplitN(s, "{", 2)[0]
+}
+
+// Set sets the value of a sample
+func (s Set) Set(name string, value float64, labels ...string) {
+	s[series(name, labels...)] = value
+}
+
+// Add adds to the value of a sample
+func (s Set) Add(name string, value float64, labels ...string) {
+	s[series(name, labels...)] += value
+}
+
+// Get returns the value of a sample, or 0 if it is not set
+func (s Set) Get(name string, labels ...string) float64 {
+	return s[series(name, labels...)]
+}
+
+// Delete removes the samples of a metric that have the label
+func (s Set) Delete(name, label, value string) {
+	pair := fmt.Sprintf("%s=%q", label, value)
+	for k := range s {
+		if metricName(k) != name {
+			continue
+		}
+		labels := strings.TrimSuffix(strings.TrimPrefix(k[len(name):], "{"), "}")
+		for _, p := range strings.Split(labels, ",") {
+			if p == pair {
+				delete(s, k)
+			}
+		}
+	}
+}
+
+// Parse reads the samples of a textfile. Comments are skipped
+func Parse(r io.Reader) (Set, error) {
+	s := Set{}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		i := strings.LastIndex(line, " ")
+		if i < 0 {
+			return nil, fmt.Errorf("invalid sample: %s", line)
+		}
+		value, err := strconv.ParseFloat(line[i+1:], 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid sample: %s", line)
+		}
+		s[strings.TrimSpace(line[:i])] = value
+	}
+	return s, scanner.Err()
+}
+
+// Write writes the samples in the text format of Prometheus, with the type
+// and help text of each metric
+func (s Set) Write(w io.Writer) error {
+	keys := []string{}
+	for k := range s {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	last := ""
+	for _, k := range keys {
+		name := metricName(k)
+		if name != last {
+			if d, ok := descriptions[name]; ok {
+				if _, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, d.help, name, d.kind); err != nil {
+					return err
+				}
+			}
+			last = name
+		}
+		if _, err := fmt.Fprintf(w, "%s %s\n", k, strconv.FormatFloat(s[k], 'f', -1, 64)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Current is the metrics of this run
+var Current = Set{}
+
+var (
+	command string
+	mark    time.Time
+)
+
+// Start starts collecting the metrics of a command
+func Start(name string) {
+	command = name
+	mark = time.Now()
+	Current = Set{}
+}
+
+// Phase records the duration of a phase of the command, since the previous
+// phase or the start
+func Phase(name string) {
+	now := time.Now()
+	Current.Set(PhaseDuration, now.Sub(mark).Seconds(), "command", command, "phase", name)
+	mark = now
+}
+
+// Load keeps the samples of a previous textfile that this run does not
+// set, so the counters carry on. The phases of the last run of the command
+// are replaced
+func Load(previous Set) {
+	previous.Delete(PhaseDuration, "command", command)
+	for k, v := range Current {
+		previous[k] = v
+	}
+	Current = previous
+}
+
+// Finish counts the run of the command and records its outcome
+func Finish(runErr error, when time.Time) {
+	result := ResultSuccess
+	success := 1.0
+	if runErr != nil {
+		result = ResultFailed
+		success = 0
+	}
+	Current.Add(RunsTotal, 1, "command", command, "result", result)
+	Current.Set(LastRunTimestamp, float64(when.Unix()), "command", command)
+	Current.Set(LastRunSuccess, success, "command", command)
+}
+
+// ReadFile reads the samples of a textfile
+func ReadFile(path string) (Set, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return Parse(f)
+}
+
+// WriteFile writes the metrics of this run to a textfile. The file is
+// renamed into place, so the collector does not read a partial file
+func WriteFile(path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+
+	err = Current.Write(f)
+	if err == nil {
+		err = f.Sync()
+	}
+	if errClose := f.Close(); err == nil {
+		err = errClose
+	}
+	if err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,76 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+// Flashback
+// Copyright 2018 Canonical Ltd.  All rights reserved.
+
+package metrics_test
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/CanonicalLtd/flashback/metrics"
+	check "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { check.TestingT(t) }
+
+type metricsSuite struct{}
+
+var _ = check.Suite(&metricsSuite{})
+
+func (s *metricsSuite) TestCarryOver(c *check.C) {
+	path := filepath.Join(c.MkDir(), "node-exporter", "flashback.prom")
+	when := time.Unix(1538000000, 0)
+
+	// First reset fails
+	metrics.Start("reset")
+	metrics.Phase("validate")
+	metrics.Current.Set(metrics.RetainedBytes, 2048)
+	metrics.Finish(errors.New("format failed"), when)
+	c.Assert(metrics.WriteFile(path), check.IsNil)
+
+	// Second reset succeeds, and the counters carry on from the textfile
+	metrics.Start("reset")
+	previous, err := metrics.ReadFile(path)
+	c.Assert(err, check.IsNil)
+	metrics.Load(previous)
+	metrics.Phase("format")
+	metrics.Finish(nil, when.Add(time.Hour))
+	c.Assert(metrics.WriteFile(path), check.IsNil)
+
+	set, err := metrics.ReadFile(path)
+	c.Assert(err, check.IsNil)
+	c.Assert(set.Get(metrics.RunsTotal, "command", "reset", "result", "failed"), check.Equals, 1.0)
+	c.Assert(set.Get(metrics.RunsTotal, "command", "reset", "result", "success"), check.Equals, 1.0)
+	c.Assert(set.Get(metrics.LastRunSuccess, "command", "reset"), check.Equals, 1.0)
+	c.Assert(set.Get(metrics.LastRunTimestamp, "command", "reset"), check.Equals, 1538003600.0)
+	c.Assert(set.Get(metrics.RetainedBytes), check.Equals, 2048.0)
+
+	// Only the phases of the last run are kept
+	_, ok := set[`flashback_phase_duration_seconds{command="reset",phase="validate"}`]
+	c.Assert(ok, check.Equals, false)
+	_, ok = set[`flashback_phase_duration_seconds{command="reset",phase="format"}`]
+	c.Assert(ok, check.Equals, true)
+}
+
+func (s *metricsSuite) TestWrite(c *check.C) {
+	set := metrics.Set{}
+	set.Set(metrics.RunsTotal, 3, "command", "bootprint", "result", "success")
+	set.Set(metrics.RecoveryImageBytes, 1.5e9)
+
+	var b strings.Builder
+	c.Assert(set.Write(&b), check.IsNil)
+	c.Assert(b.String(), check.Equals, `# HELP flashback_recovery_image_bytes Size of the files on the restore partition.
+# TYPE flashback_recovery_image_bytes gauge
+flashback_recovery_image_bytes 1500000000
+# HELP flashback_runs_total Runs of flashback by command and result.
+# TYPE flashback_runs_total counter
+flashback_runs_total{command="bootprint",result="success"} 3
+`)
+
+	_, err := metrics.Parse(strings.NewReader("flashback_runs_total three\n"))
+	c.Assert(err, check.NotNil)
+}
@@ -0,0 +1,15 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+// Flashback
+// Copyright 2018 Canonical Ltd.  All rights reserved.
+
+package reset
+
+// Internals of the snap data areas, for the tests
+var (
+	SnapAreaPath    = snapAreaPath
+	BackupSnapData  = backupSnapData
+	RestoreSnapData = restoreSnapData
+)
+
+// ReadDeltaSums reads the checksums of a delta, for the tests
+var ReadDeltaSums = readDeltaSums
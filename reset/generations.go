@@ -0,0 +1,52 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+// Flashback
+// Copyright 2018 Canonical Ltd.  All rights reserved.
+
+package reset
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/CanonicalLtd/flashback/audit"
+	"github.com/CanonicalLtd/flashback/core"
+	"github.com/CanonicalLtd/flashback/manifest"
+)
+
+// LatestGeneration selects the newest generation of the recovery image
+const LatestGeneration = -1
+
+// Generation is the generation of the recovery image to restore. Generation
+// 0 is the full writable archive without a delta
+var Generation = LatestGeneration
+
+// selectDelta finds the delta archive of the generation to restore, and the
+// number of the generation. Returns an empty path for the full archive. The
+// restore partition must be mounted
+func selectDelta() (string, int, error) {
+	if Generation == 0 {
+		return "", 0, nil
+	}
+
+	m, err := manifest.Read(core.ManifestFile)
+	if os.IsNotExist(err) && Generation == LatestGeneration {
+		return "", 0, nil
+	}
+	if err != nil {
+		return "", 0, err
+	}
+
+	g := m.Latest()
+	if Generation != LatestGeneration {
+		g = m.Generation(Generation)
+		if g == nil {
+			return "", 0, core.NewError(core.FailureImageMissing, fmt.Errorf("generation %d is not in the recovery image", Generation))
+		}
+	}
+	if g == nil {
+		return "", 0, nil
+	}
+
+	audit.Printf("Restore generation %d, created %s\n", g.Number, g.Created)
+	return core.DeltaArchive(g.Number), g.Number, nil
+}
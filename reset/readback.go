@@ -0,0 +1,148 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+// Flashback
+// Copyright 2018 Canonical Ltd.  All rights reserved.
+
+package reset
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/CanonicalLtd/flashback/audit"
+	"github.com/CanonicalLtd/flashback/core"
+	"github.com/CanonicalLtd/flashback/manifest"
+)
+
+// readBackSystemBoot reads system-boot back from the device and compares it
+// with the image that was written and the checksum recorded by bootprint
+func readBackSystemBoot(written core.Digest) error {
+	if err := core.MountReadOnly(core.PartitionTable.Restore, core.RestorePath); err != nil {
+		return err
+	}
+	m, err := manifest.Read(core.ManifestFile)
+	_ = core.Unmount(core.RestorePath)
+
+	var recorded *manifest.Image
+	if err == nil {
+		recorded = m.SystemBoot
+	}
+	return readBackImage("system-boot", core.PartitionTable.SystemBoot, written, recorded)
+}
+
+// readBackImage reads a partition back from the device and compares it with
+// the image that was written and the checksum recorded by bootprint, if any
+func readBackImage(name, device string, written core.Digest, recorded *manifest.Image) error {
+	audit.Printf("Read back the %s partition\n", name)
+
+	if recorded != nil {
+		if recorded.Size != written.Size || recorded.SHA256 != written.SHA256 {
+			return fmt.Errorf("%s image does not match the checksum in the manifest", name)
+		}
+	} else {
+		audit.Printf("No checksum of %s in the manifest, compare with the image that was written\n", name)
+	}
+
+	if err := core.DropCaches(); err != nil {
+		audit.Println("Cannot drop the page cache:", err)
+	}
+
+	sum, err := core.HashDevice(device, written.Size)
+	if err != nil {
+		return err
+	}
+	if sum != written.SHA256 {
+		return fmt.Errorf("%s checksum %s, expected %s", name, sum, written.SHA256)
+	}
+
+	audit.Printf("The %s partition matches the recovery image: %d bytes\n", name, written.Size)
+	return nil
+}
+
+// readBackWritable reads the restored files back from writable and compares
+// them with the checksums recorded by bootprint for the full archive and the
+// delta. A recovery image without the checksums cannot be read back, which
+// fails the read-back
+func readBackWritable(delta string) error {
+	audit.Println("Read back the writable partition")
+
+	if err := core.MountReadOnly(core.PartitionTable.Restore, core.RestorePath); err != nil {
+		return err
+	}
+	defer core.Unmount(core.RestorePath)
+
+	m, err := manifest.Read(core.ManifestFile)
+	if err != nil {
+		return fmt.Errorf("cannot read the manifest of the recovery image: %v", err)
+	}
+	if len(m.WritableSums) == 0 {
+		return fmt.Errorf("no checksums of writable in the recovery image")
+	}
+
+	// The files of the delta are checked against the checksums of the delta.
+	// Any path the delta changed or deleted is not checked against the full
+	// archive, as it may now be a link or a directory
+	changed := map[string]string{}
+	skip := map[string]bool{}
+	if len(delta) > 0 {
+		if changed, skip, err = readDeltaSums(delta); err != nil {
+			return err
+		}
+	}
+
+	sums, err := os.Open(core.SumsFile(core.BackupImageWritable))
+	if err != nil {
+		return err
+	}
+	defer sums.Close()
+
+	if err := core.MountReadOnly(core.PartitionTable.Writable, core.WritablePath); err != nil {
+		return err
+	}
+	defer core.Unmount(core.WritablePath)
+
+	if err := core.DropCaches(); err != nil {
+		audit.Println("Cannot drop the page cache:", err)
+	}
+
+	count, err := core.VerifySums(core.WritablePath, sums, func(name string) bool {
+		return skip[name]
+	})
+	if err != nil {
+		return err
+	}
+	countDelta, err := core.VerifySumsMap(core.WritablePath, changed)
+	if err != nil {
+		return err
+	}
+
+	audit.Printf("Writable matches the recovery image: %d files\n", count+countDelta)
+	return nil
+}
+
+// readDeltaSums reads the checksums of the changed files of a delta, and the
+// paths of all the entries it changed or deleted
+func readDeltaSums(delta string) (map[string]string, map[string]bool, error) {
+	f, err := os.Open(core.SumsFile(delta))
+	if os.IsNotExist(err) {
+		return nil, nil, fmt.Errorf("no checksums of the delta `%s` in the recovery image", delta)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+
+	changed, err := core.ReadSums(f)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	names, deleted, err := core.ArchiveDeltaLists(delta)
+	if err != nil {
+		return nil, nil, err
+	}
+	skip := map[string]bool{}
+	for _, name := range append(names, deleted...) {
+		skip[name] = true
+	}
+	return changed, skip, nil
+}
@@ -0,0 +1,19 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+// Flashback
+// Copyright 2018 Canonical Ltd.  All rights reserved.
+
+package reset_test
+
+import (
+	"path/filepath"
+
+	"github.com/CanonicalLtd/flashback/reset"
+	check "gopkg.in/check.v1"
+)
+
+func (s *resetSuite) TestReadDeltaSumsMissing(c *check.C) {
+	// A delta without checksums cannot be read back
+	delta := filepath.Join(c.MkDir(), "writable.1.tar.gz")
+	_, _, err := reset.ReadDeltaSums(delta)
+	c.Assert(err, check.ErrorMatches, "no checksums of the delta .* in the recovery image")
+}
@@ -8,65 +8,124 @@ import (
 	"github.com/CanonicalLtd/flashback/audit"
 	"github.com/CanonicalLtd/flashback/config"
 	"github.com/CanonicalLtd/flashback/core"
+	"github.com/CanonicalLtd/flashback/metrics"
 )
 
+// Result is what a factory reset restored, for the history
+type Result struct {
+	// Generation is the generation of the recovery image that was restored
+	Generation int
+	// Retained is the paths on system-data that were kept
+	Retained []string
+}
+
 // Run starts the factory reset
-func Run() error {
+func Run() (Result, error) {
+	result := Result{}
+
 	audit.Println("Start a factory reset of the device")
 
 	// Find the partition devices
 	err := core.FindPartitions()
 	if err != nil {
-		return err
+		return result, err
+	}
+
+	// Warn if the recovery image may have been changed
+	core.CheckRestoreProtected()
+
+	// Make sure the clock is not behind the recovery image
+	if err := core.AdvanceClockFromRestore(); err != nil {
+		audit.Println("Error setting the clock:", err)
+	}
+
+	// Check the recovery image before writable is touched
+	delta, generation, err := validateRecoveryImage()
+	if err != nil {
+		audit.Println("Recovery image is missing or corrupt, the factory reset is aborted")
+		return result, err
 	}
+	metrics.Phase("validate")
+	result.Generation = generation
 
 	// Create a RAM disk copy of the restore partition
 	if err := core.CreateTmpfsDisk(core.TempFSMount, config.Store.Backup.Size); err != nil {
-		return err
+		return result, err
 	}
 
 	// Back up the requested data to the RAM disk copy of restore
-	if err := backupUserData(); err != nil {
+	if result.Retained, err = backupUserData(); err != nil {
 		audit.Println("Error backing up user data to copy of `restore` partition")
-		return err
-	}
-
-	// Get the partition type of writable
-	fsType, err := core.FSType(core.PartitionTable.Writable)
-	if err != nil {
-		return err
+		return result, err
 	}
+	metrics.Phase("retain")
 
 	// Format the writable partition
-	_ = core.Unmount(core.PartitionTable.Writable)
-	if err := core.FormatDisk(core.PartitionTable.Writable, fsType, core.PartitionWritable); err != nil {
-		audit.Println("Error formatting the `writable` partition")
-		return err
+	name, partition := core.WritablePartition()
+	if err := formatPartition(core.PartitionTable.Writable, name, partition); err != nil {
+		return result, err
 	}
+	metrics.Phase("format")
 
 	// Restore writable from the backup file on the restore partition
-	if err := restoreWritable(); err != nil {
+	if err := restoreWritable(delta); err != nil {
 		audit.Println("Error restoring the `writable` partition")
-		return err
+		return result, core.NewError(core.FailureRestore, err)
+	}
+	if config.Store.Verify.ReadBack {
+		if err := readBackWritable(delta); err != nil {
+			audit.Println("Error reading back the `writable` partition")
+			return result, core.NewError(core.FailureReadBack, err)
+		}
+	}
+	metrics.Phase("writable")
+
+	// Restore system-boot to virgin state by rewriting the partition from the
+	// backup, unless ubuntu-boot is kept
+	if core.SystemBootImaged() {
+		audit.Println("Restore system-boot to its first-boot state")
+		written, err := restoreSystemBoot()
+		if err != nil {
+			return result, core.NewError(core.FailureRestore, err)
+		}
+		if config.Store.Verify.ReadBack {
+			if err := readBackSystemBoot(written); err != nil {
+				audit.Println("Error reading back the `system-boot` partition")
+				return result, core.NewError(core.FailureReadBack, err)
+			}
+		}
 	}
 
-	// Restore system-boot to virgin state by rewriting the partition from the backup
-	audit.Println("Restore system-boot to its first-boot state")
-	if err = restoreSystemBoot(); err != nil {
-		return err
+	// Restore the other partitions of the Ubuntu Core 20 layout
+	if err := restoreRoles(); err != nil {
+		return result, err
 	}
+	metrics.Phase("boot")
+
+	// Restore the A/B boot slots and select the slot that was active at bootprint
+	if err := restoreSlots(); err != nil {
+		return result, err
+	}
+	metrics.Phase("slots")
 
 	// Restore backed up data
 	if err := restoreUserData(); err != nil {
-		return err
+		return result, core.NewError(core.FailureRestore, err)
 	}
-
-	audit.Println("Factory reset completed successfully")
+	metrics.Phase("restore-data")
 
 	_ = core.Unmount(core.WritablePath)
 	_ = core.Unmount(core.RestorePath)
 	_ = core.Unmount(core.TempFSMount)
 
+	// Only report success once the restored partitions are on the disk
+	if err := core.SyncDevices(restoredDevices()...); err != nil {
+		return result, core.NewError(core.FailureRestore, err)
+	}
+	metrics.Phase("sync")
+
+	audit.Println("Factory reset completed successfully")
+
 	// Initiate reboot
-	return nil
+	return result, nil
 }
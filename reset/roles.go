@@ -0,0 +1,136 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+// Flashback
+// Copyright 2018 Canonical Ltd.  All rights reserved.
+
+package reset
+
+import (
+	"path/filepath"
+
+	"github.com/CanonicalLtd/flashback/audit"
+	"github.com/CanonicalLtd/flashback/config"
+	"github.com/CanonicalLtd/flashback/core"
+)
+
+// formatPartition formats a partition with its current filesystem, keeping
+// its current label and UUID so references e.g. root=UUID= still work. The
+// label and UUID in the config take precedence, and the label defaults to
+// the partition name if the filesystem has none
+func formatPartition(device, name string, p config.Partition) error {
+	fsType, err := core.FSType(device)
+	if err != nil {
+		return err
+	}
+
+	label, uuid := p.Label, p.UUID
+	if len(label) == 0 {
+		label, _ = core.FSTag(device, core.TagLabel)
+	}
+	if len(label) == 0 {
+		label = name
+	}
+	if len(uuid) == 0 {
+		uuid, _ = core.FSTag(device, core.TagUUID)
+	}
+	audit.Printf("Format the `%s` partition as %s, label `%s`, UUID `%s`\n", name, fsType, label, uuid)
+	_ = core.Unmount(device)
+	if err := core.FormatDisk(device, fsType, label, uuid); err != nil {
+		audit.Printf("Error formatting the `%s` partition\n", name)
+		return core.NewError(core.FailureFormat, err)
+	}
+	return nil
+}
+
+// validateRoles checks the backups of the partitions of the Ubuntu Core 20
+// layout. The restore partition must be mounted
+func validateRoles() error {
+	for _, r := range core.PartitionTable.ExtraRoles() {
+		var err error
+		switch r.Action {
+		case config.RoleImage:
+			_, err = core.ValidateGzip(r.Backup())
+		case config.RoleArchive:
+			_, err = core.ValidateTarGz(r.Backup())
+		}
+		if err != nil {
+			audit.Printf("Invalid %s backup: %v\n", r.Name, err)
+			return err
+		}
+		audit.Printf("The %s backup is valid\n", r.Name)
+	}
+	return nil
+}
+
+// restoreRoles restores the partitions of the Ubuntu Core 20 layout besides
+// ubuntu-boot and ubuntu-data. Partitions that are kept are left alone
+func restoreRoles() error {
+	for _, r := range core.PartitionTable.ExtraRoles() {
+		audit.Printf("Restore the %s partition from %s\n", r.Name, r.Backup())
+
+		var err error
+		switch r.Action {
+		case config.RoleImage:
+			err = restoreRoleImage(r)
+		case config.RoleArchive:
+			err = restoreRoleArchive(r)
+		}
+		if err != nil {
+			return core.NewError(core.FailureRestore, err)
+		}
+	}
+	return nil
+}
+
+func restoreRoleImage(r core.Role) error {
+	_, err := restoreImage(r.Backup(), r.Device)
+	return err
+}
+
+// restoreRoleArchive formats a partition and extracts its archive. The
+// entries are under a directory named after the role, which is the mount point
+func restoreRoleArchive(r core.Role) error {
+	if err := formatPartition(r.Device, r.Name, roleConfig(r.Name)); err != nil {
+		return err
+	}
+
+	if err := core.Mount(r.Device, r.MountPath()); err != nil {
+		return err
+	}
+	defer core.Unmount(r.MountPath())
+
+	if err := core.MountReadOnly(core.PartitionTable.Restore, core.RestorePath); err != nil {
+		return err
+	}
+	defer core.Unmount(core.RestorePath)
+
+	return extractArchive(r.Backup(), filepath.Dir(r.MountPath()), nil)
+}
+
+// roleConfig returns the config of a partition of the Ubuntu Core 20 layout
+func roleConfig(name string) config.Partition {
+	switch name {
+	case core.PartitionUbuntuSeed:
+		return config.Store.Partitions.UbuntuSeed
+	case core.PartitionUbuntuBoot:
+		return config.Store.Partitions.UbuntuBoot
+	case core.PartitionUbuntuSave:
+		return config.Store.Partitions.UbuntuSave
+	default:
+		return config.Store.Partitions.UbuntuData
+	}
+}
+
+// restoredDevices lists the devices that a factory reset writes to
+func restoredDevices() []string {
+	devices := []string{core.PartitionTable.Writable}
+	if len(core.PartitionTable.SystemBoot) > 0 {
+		devices = append(devices, core.PartitionTable.SystemBoot)
+	}
+	for _, r := range core.PartitionTable.ExtraRoles() {
+		devices = append(devices, r.Device)
+	}
+	for _, s := range core.PartitionTable.Slots {
+		devices = append(devices, s.Device)
+	}
+	return devices
+}
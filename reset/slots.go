@@ -0,0 +1,95 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+// Flashback
+// Copyright 2018 Canonical Ltd.  All rights reserved.
+
+package reset
+
+import (
+	"fmt"
+
+	"github.com/CanonicalLtd/flashback/audit"
+	"github.com/CanonicalLtd/flashback/config"
+	"github.com/CanonicalLtd/flashback/core"
+	"github.com/CanonicalLtd/flashback/manifest"
+)
+
+// slotsToRestore finds the boot slots to restore, and the slot that was
+// active at bootprint. The restore partition must be mounted. The errors are
+// classified by the caller, as they mean different things before and after
+// writable is formatted
+func slotsToRestore() ([]core.Slot, core.Slot, *manifest.Manifest, error) {
+	m, err := manifest.Read(core.ManifestFile)
+	if err != nil {
+		return nil, core.Slot{}, nil, err
+	}
+
+	active, ok := core.PartitionTable.Slot(m.ActiveSlot)
+	if !ok {
+		return nil, core.Slot{}, nil, fmt.Errorf("the active boot slot `%s` in the manifest is not a slot", m.ActiveSlot)
+	}
+
+	if config.Store.Slots.Restore == config.SlotsActive {
+		return []core.Slot{active}, active, m, nil
+	}
+	return core.PartitionTable.Slots, active, m, nil
+}
+
+// validateSlots checks the images of the boot slots to restore. The restore
+// partition must be mounted
+func validateSlots() error {
+	if len(core.PartitionTable.Slots) == 0 {
+		return nil
+	}
+
+	slots, _, _, err := slotsToRestore()
+	if err != nil {
+		audit.Println("Cannot find the boot slots to restore:", err)
+		return core.NewError(core.FailureImageMissing, err)
+	}
+	for _, s := range slots {
+		size, err := core.ValidateGzip(s.Image())
+		if err != nil {
+			audit.Printf("Invalid image of the boot slot `%s`: %v\n", s.Name, err)
+			return err
+		}
+		audit.Printf("Image of the boot slot `%s` is valid: %d bytes\n", s.Name, size)
+	}
+	return nil
+}
+
+// restoreSlots restores the boot slots from their images, and sets the slot
+// selector back to the slot that was active at bootprint
+func restoreSlots() error {
+	if len(core.PartitionTable.Slots) == 0 {
+		return nil
+	}
+
+	if err := core.MountReadOnly(core.PartitionTable.Restore, core.RestorePath); err != nil {
+		return core.NewError(core.FailureRestore, err)
+	}
+	slots, active, m, err := slotsToRestore()
+	_ = core.Unmount(core.RestorePath)
+	if err != nil {
+		return core.NewError(core.FailureRestore, err)
+	}
+
+	for _, s := range slots {
+		audit.Printf("Restore the boot slot `%s` to its first-boot state\n", s.Name)
+		written, err := restoreImage(s.Image(), s.Device)
+		if err != nil {
+			return core.NewError(core.FailureRestore, err)
+		}
+		if config.Store.Verify.ReadBack {
+			if err := readBackImage("boot slot "+s.Name, s.Device, written, m.Slots[s.Name]); err != nil {
+				audit.Printf("Error reading back the boot slot `%s`\n", s.Name)
+				return core.NewError(core.FailureReadBack, err)
+			}
+		}
+	}
+
+	if err := core.SelectSlot(active.Name); err != nil {
+		audit.Println("Error setting the slot selector")
+		return core.NewError(core.FailureRestore, err)
+	}
+	return nil
+}
@@ -0,0 +1,132 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+// Flashback
+// Copyright 2018 Canonical Ltd.  All rights reserved.
+
+package reset
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/CanonicalLtd/flashback/audit"
+	"github.com/CanonicalLtd/flashback/config"
+	"github.com/CanonicalLtd/flashback/core"
+)
+
+const (
+	// snapDataPath is the directory of the snap data on system-data
+	snapDataPath = "/var/snap"
+	// snapCurrent is the link to the current revision of a snap
+	snapCurrent = "current"
+	// tempSnapsDir is the directory of the snap data in the tmpfs store
+	tempSnapsDir = "snaps"
+)
+
+// backupSnapData backs up the data areas of the snaps to the tmpfs store.
+// The `current` area is read from the revision that the link points to.
+// The writable partition must be mounted. Returns the paths of the areas
+// that were kept
+func backupSnapData() ([]string, error) {
+	retained := []string{}
+	for _, snap := range config.Store.Backup.Snaps {
+		for _, area := range snap.Data {
+			path, err := snapAreaPath(snap.Name, area)
+			if err != nil {
+				audit.Printf("Cannot find the `%s` data of `%s`: %v\n", area, snap.Name, err)
+				continue
+			}
+			if _, err := os.Stat(path); os.IsNotExist(err) {
+				audit.Println("Path not found:", path)
+				continue
+			}
+
+			audit.Printf("Backup the `%s` data of `%s`\n", area, snap.Name)
+			if err := copyPath(path, tempSnapPath(snap.Name, area)); err != nil {
+				return nil, err
+			}
+			retained = append(retained, filepath.Join(snapDataPath, snap.Name, area))
+		}
+	}
+
+	return retained, nil
+}
+
+// restoreSnapData restores the data areas of the snaps from the tmpfs store.
+// The `current` area is restored to the revision of the snap on the new
+// writable partition, which must be mounted. Snaps without a `current`
+// revision on the new writable partition are skipped
+func restoreSnapData() error {
+	for _, snap := range config.Store.Backup.Snaps {
+		if _, err := os.Stat(tempSnapPath(snap.Name, "")); os.IsNotExist(err) {
+			continue
+		}
+
+		// None of the data is restored for a snap that is not installed,
+		// so its `common` area is not created without a revision
+		if _, err := os.Readlink(filepath.Join(snapBasePath(snap.Name), snapCurrent)); err != nil {
+			audit.Printf("Skip the data of `%s`, the snap is not installed: %v\n", snap.Name, err)
+			continue
+		}
+
+		for _, area := range snap.Data {
+			tempPath := tempSnapPath(snap.Name, area)
+			if _, err := os.Stat(tempPath); os.IsNotExist(err) {
+				continue
+			}
+
+			path, err := snapAreaPath(snap.Name, area)
+			if err != nil {
+				audit.Printf("Skip the `%s` data of `%s`, the snap is not installed: %v\n", area, snap.Name, err)
+				continue
+			}
+
+			audit.Printf("Restore the `%s` data of `%s`\n", area, snap.Name)
+			if err := copyPath(tempPath, path); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// snapAreaPath converts a data area of a snap to its path on writable,
+// resolving the `current` link to the revision of the snap
+func snapAreaPath(name, area string) (string, error) {
+	base := snapBasePath(name)
+
+	parts := strings.SplitN(filepath.Clean(area), "/", 2)
+	if parts[0] == snapCurrent {
+		revision, err := os.Readlink(filepath.Join(base, snapCurrent))
+		if err != nil {
+			return "", err
+		}
+		parts[0] = filepath.Base(revision)
+	}
+
+	return filepath.Join(base, filepath.Join(parts...)), nil
+}
+
+// snapBasePath is the directory of the data of a snap on writable
+func snapBasePath(name string) string {
+	return filepath.Join(core.WritablePath, core.SystemData, snapDataPath, name)
+}
+
+// tempSnapPath is the path of a data area of a snap in the tmpfs store
+func tempSnapPath(name, area string) string {
+	return filepath.Join(core.TempFSMount, tempSnapsDir, name, filepath.Clean(area))
+}
+
+// copyPath copies a file, or the contents of a directory, to the target path
+func copyPath(source, target string) error {
+	info, err := os.Stat(source)
+	if err != nil {
+		return err
+	}
+
+	if info.IsDir() {
+		return core.CopyDirectoryContents(source, target)
+	}
+	return core.CopyFile(source, target)
+}
@@ -0,0 +1,120 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+// Flashback
+// Copyright 2018 Canonical Ltd.  All rights reserved.
+
+package reset_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/CanonicalLtd/flashback/config"
+	"github.com/CanonicalLtd/flashback/core"
+	"github.com/CanonicalLtd/flashback/reset"
+	check "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { check.TestingT(t) }
+
+type resetSuite struct {
+	writable string
+	tmpfs    string
+}
+
+var _ = check.Suite(&resetSuite{})
+
+func (s *resetSuite) SetUpTest(c *check.C) {
+	s.writable = c.MkDir()
+	s.tmpfs = c.MkDir()
+	core.WritablePath = s.writable
+	core.TempFSMount = s.tmpfs
+	config.Store.Backup.Snaps = nil
+}
+
+func (s *resetSuite) TearDownTest(c *check.C) {
+	core.WritablePath = config.DefaultWritableMount
+	core.TempFSMount = config.DefaultTmpfsMount
+	config.Store.Backup.Snaps = nil
+}
+
+// snapDir is the directory of the data of a snap on the fake writable
+func (s *resetSuite) snapDir(name string) string {
+	return filepath.Join(s.writable, core.SystemData, "var", "snap", name)
+}
+
+// installSnap creates the data directories of a revision of a snap, and
+// points the `current` link to it
+func (s *resetSuite) installSnap(c *check.C, name, revision string) {
+	dir := s.snapDir(name)
+	c.Assert(os.MkdirAll(filepath.Join(dir, revision), 0755), check.IsNil)
+	c.Assert(os.MkdirAll(filepath.Join(dir, "common"), 0755), check.IsNil)
+	_ = os.Remove(filepath.Join(dir, "current"))
+	c.Assert(os.Symlink(revision, filepath.Join(dir, "current")), check.IsNil)
+}
+
+func (s *resetSuite) TestSnapAreaPath(c *check.C) {
+	s.installSnap(c, "nm", "x12")
+
+	tests := []struct {
+		area string
+		path string
+	}{
+		{"current", "x12"},
+		{"current/conf", "x12/conf"},
+		{"common", "common"},
+		{"common/db/", "common/db"},
+		{"x3", "x3"},
+	}
+	for _, t := range tests {
+		path, err := reset.SnapAreaPath("nm", t.area)
+		c.Assert(err, check.IsNil)
+		c.Assert(path, check.Equals, filepath.Join(s.snapDir("nm"), t.path), check.Commentf(t.area))
+	}
+
+	// The `current` revision of a snap that is not installed is not known
+	_, err := reset.SnapAreaPath("absent", "current")
+	c.Assert(err, check.NotNil)
+	path, err := reset.SnapAreaPath("absent", "common")
+	c.Assert(err, check.IsNil)
+	c.Assert(path, check.Equals, filepath.Join(s.snapDir("absent"), "common"))
+}
+
+func (s *resetSuite) TestRestoreSnapDataToNewRevision(c *check.C) {
+	config.Store.Backup.Snaps = []config.Snap{
+		{Name: "nm", Data: []string{"current", "common"}},
+		{Name: "absent", Data: []string{"current", "common"}},
+	}
+
+	// The data of the revision that was current before the reset
+	s.installSnap(c, "nm", "x12")
+	s.installSnap(c, "absent", "x7")
+	c.Assert(ioutil.WriteFile(filepath.Join(s.snapDir("absent"), "common", "cache"), []byte("old"), 0644), check.IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(s.snapDir("nm"), "x12", "connections"), []byte("wifi"), 0644), check.IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(s.snapDir("nm"), "common", "leases"), []byte("dhcp"), 0644), check.IsNil)
+	retained, err := reset.BackupSnapData()
+	c.Assert(err, check.IsNil)
+	c.Assert(retained, check.DeepEquals, []string{"/var/snap/nm/current", "/var/snap/nm/common", "/var/snap/absent/current", "/var/snap/absent/common"})
+
+	// The reset restores the writable partition, with an older revision of
+	// nm and without the other snap
+	c.Assert(os.RemoveAll(filepath.Join(s.writable, core.SystemData)), check.IsNil)
+	s.installSnap(c, "nm", "x3")
+
+	c.Assert(reset.RestoreSnapData(), check.IsNil)
+
+	dat, err := ioutil.ReadFile(filepath.Join(s.snapDir("nm"), "x3", "connections"))
+	c.Assert(err, check.IsNil)
+	c.Assert(string(dat), check.Equals, "wifi")
+	dat, err = ioutil.ReadFile(filepath.Join(s.snapDir("nm"), "common", "leases"))
+	c.Assert(err, check.IsNil)
+	c.Assert(string(dat), check.Equals, "dhcp")
+
+	// The revision from before the reset is not created, nor the data of
+	// the snap that is not installed, even its `common` area
+	_, err = os.Stat(filepath.Join(s.snapDir("nm"), "x12"))
+	c.Assert(os.IsNotExist(err), check.Equals, true)
+	_, err = os.Stat(s.snapDir("absent"))
+	c.Assert(os.IsNotExist(err), check.Equals, true)
+}
@@ -8,22 +8,29 @@ import (
 	"github.com/CanonicalLtd/flashback/core"
 )
 
-// restoreSystemBoot restores system-boot from the raw backup
-func restoreSystemBoot() error {
+// restoreSystemBoot restores system-boot from the raw backup. Returns the
+// size and checksum of the content that was written
+func restoreSystemBoot() (core.Digest, error) {
+	return restoreImage(core.BackupImageSystemBoot, core.PartitionTable.SystemBoot)
+}
+
+// restoreImage writes a raw backup on the restore partition to a device.
+// Returns the size and checksum of the content that was written
+func restoreImage(image, device string) (core.Digest, error) {
 	// Mount the restore path
-	err := core.Mount(core.PartitionTable.Restore, core.RestorePath)
+	err := core.MountReadOnly(core.PartitionTable.Restore, core.RestorePath)
 	if err != nil {
-		return err
+		return core.Digest{}, err
 	}
 
 	// Unmount the boot path
-	_ = core.Unmount(core.PartitionTable.SystemBoot)
+	_ = core.Unmount(device)
 
 	// Write partition content back
-	err = core.UnzipToDevice(core.BackupImageSystemBoot, core.PartitionTable.SystemBoot)
+	digest, err := core.UnzipToDevice(image, device)
 
 	// Unmount the restore partition
 	_ = core.Unmount(core.RestorePath)
 
-	return err
+	return digest, err
 }
@@ -7,18 +7,22 @@ package reset
 import (
 	"os"
 	"path/filepath"
+	"syscall"
 
 	"github.com/CanonicalLtd/flashback/audit"
 	"github.com/CanonicalLtd/flashback/config"
 	"github.com/CanonicalLtd/flashback/core"
+	"github.com/CanonicalLtd/flashback/metrics"
 )
 
-// backupUserData backs up the requested data to the RAM disk
-func backupUserData() error {
+// backupUserData backs up the requested data to the RAM disk. Returns the
+// paths on system-data that were kept
+func backupUserData() ([]string, error) {
 	audit.Println("Backup user data to the tmpfs store")
+	retained := []string{}
 	// Mount the writable path
 	if err := core.Mount(core.PartitionTable.Writable, core.WritablePath); err != nil {
-		return err
+		return nil, err
 	}
 
 	// Backup the directories to tmpfs
@@ -37,7 +41,7 @@ func backupUserData() error {
 			// Move up a directory so we don't create nested directories
 			target, err = filepath.Abs(filepath.Join(target, ".."))
 			if err != nil {
-				return err
+				return nil, err
 			}
 			err = core.CopyDirectory(path, target)
 		} else {
@@ -46,14 +50,27 @@ func backupUserData() error {
 		}
 		if err != nil {
 			_ = core.Unmount(core.WritablePath)
-			return err
+			return nil, err
 		}
+		retained = append(retained, d)
 	}
 
+	// Backup the snap data areas to tmpfs
+	snaps, err := backupSnapData()
+	if err != nil {
+		_ = core.Unmount(core.WritablePath)
+		return nil, err
+	}
+	retained = append(retained, snaps...)
+
 	// Unmount the writable partition
 	_ = core.Unmount(core.WritablePath)
 
-	return nil
+	if size, err := core.DirSize(core.TempFSMount); err == nil {
+		audit.Printf("Retained %d bytes of user data\n", size)
+		metrics.Current.Set(metrics.RetainedBytes, float64(size))
+	}
+	return retained, nil
 }
 
 // restoreUserData restores the requested data from the tmpfs store
@@ -93,6 +110,14 @@ func restoreUserData() error {
 		}
 	}
 
+	// Restore the snap data areas
+	if err := restoreSnapData(); err != nil {
+		return err
+	}
+
+	// Make sure the retained data is on the disk
+	syscall.Sync()
+
 	// Unmount the partitions
 	_ = core.Unmount(core.WritablePath)
 	_ = core.Unmount(core.TempFSMount)
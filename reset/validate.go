@@ -0,0 +1,70 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+// Flashback
+// Copyright 2018 Canonical Ltd.  All rights reserved.
+
+package reset
+
+import (
+	"os"
+
+	"github.com/CanonicalLtd/flashback/audit"
+	"github.com/CanonicalLtd/flashback/core"
+)
+
+// validateRecoveryImage reads the recovery image end-to-end before anything
+// is changed, so a truncated or corrupt image does not leave the device unbootable.
+// Returns the delta archive of the generation to restore, if there is one,
+// and the number of the generation
+func validateRecoveryImage() (string, int, error) {
+	audit.Println("Validate the recovery image")
+	if err := core.MountReadOnly(core.PartitionTable.Restore, core.RestorePath); err != nil {
+		return "", 0, err
+	}
+	defer core.Unmount(core.RestorePath)
+
+	delta, generation, err := selectDelta()
+	if err != nil {
+		audit.Println("Cannot find the generation to restore:", err)
+		return "", 0, imageError(err)
+	}
+
+	archives := []string{core.BackupImageWritable}
+	if len(delta) > 0 {
+		archives = append(archives, delta)
+	}
+	for _, archive := range archives {
+		count, err := core.ValidateTarGz(archive)
+		if err != nil {
+			audit.Println("Invalid writable backup:", err)
+			return "", 0, imageError(err)
+		}
+		audit.Printf("Writable backup is valid: %d entries\n", count)
+	}
+
+	if core.SystemBootImaged() {
+		size, err := core.ValidateGzip(core.BackupImageSystemBoot)
+		if err != nil {
+			audit.Println("Invalid system-boot image:", err)
+			return "", 0, imageError(err)
+		}
+		audit.Printf("System-boot image is valid: %d bytes\n", size)
+	}
+
+	if err := validateRoles(); err != nil {
+		return "", 0, imageError(err)
+	}
+
+	if err := validateSlots(); err != nil {
+		return "", 0, imageError(err)
+	}
+
+	return delta, generation, nil
+}
+
+// imageError classifies an error reading the recovery image
+func imageError(err error) error {
+	if os.IsNotExist(err) {
+		return core.NewError(core.FailureImageMissing, err)
+	}
+	return core.NewError(core.FailureImageCorrupt, err)
+}
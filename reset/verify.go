@@ -0,0 +1,31 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+// Flashback
+// Copyright 2018 Canonical Ltd.  All rights reserved.
+
+package reset
+
+import (
+	"github.com/CanonicalLtd/flashback/audit"
+	"github.com/CanonicalLtd/flashback/core"
+)
+
+// Verify checks that the recovery image is complete and not corrupt, so a
+// factory reset can be run from it
+func Verify() error {
+	audit.Println("Verify the recovery image")
+
+	// Find the partition devices
+	if err := core.FindPartitions(); err != nil {
+		return err
+	}
+
+	// Warn if the recovery image may have been changed
+	core.CheckRestoreProtected()
+
+	if _, _, err := validateRecoveryImage(); err != nil {
+		return err
+	}
+
+	audit.Println("Recovery image is valid")
+	return nil
+}
@@ -7,31 +7,51 @@ package reset
 import (
 	"archive/tar"
 	"compress/gzip"
+	"fmt"
 	"io"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"github.com/CanonicalLtd/flashback/audit"
 	"github.com/CanonicalLtd/flashback/core"
 )
 
-// restoreWritable restores a backup of the files to the writable partition
+// restoreWritable restores a backup of the files to the writable partition,
+// then applies the delta of a generation if one is given
 // We don't use an image as we'd need to regenerate the encryption key
-func restoreWritable() error {
+func restoreWritable(delta string) error {
 	audit.Println("Restore the writable partition from the backup")
 	// Mount the writable path
 	if err := core.Mount(core.PartitionTable.Writable, core.WritablePath); err != nil {
 		return err
 	}
+	defer core.Unmount(core.WritablePath)
 
 	// Mount the restore path
-	err := core.Mount(core.PartitionTable.Restore, core.RestorePath)
+	err := core.MountReadOnly(core.PartitionTable.Restore, core.RestorePath)
 	if err != nil {
 		return err
 	}
+	defer core.Unmount(core.RestorePath)
 
+	if err := extractArchive(core.BackupImageWritable, core.WritablePath, nil); err != nil {
+		return err
+	}
+
+	if len(delta) > 0 {
+		audit.Println("Apply the delta", delta)
+		if err := extractArchive(delta, core.WritablePath, removeDeleted); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// extractArchive extracts a gzipped tar file to a directory
+func extractArchive(path, dest string, hook core.ExtractHook) error {
 	// Open the tar file
-	tarfile, err := os.Open(core.BackupImageWritable)
+	tarfile, err := os.Open(path)
 	if err != nil {
 		return err
 	}
@@ -44,51 +64,36 @@ func restoreWritable() error {
 	}
 	defer gr.Close()
 
-	// Open the tar reader
-	tr := tar.NewReader(gr)
-
-	for {
-		header, err := tr.Next()
-		switch {
-		// if no more files are found return
-		case err == io.EOF:
-			// Unmount the writable partition
-			_ = core.Unmount(core.WritablePath)
-			_ = core.Unmount(core.RestorePath)
-			return nil
-		// return any other error
-		case err != nil:
-			return err
-		// if the header is nil, just skip it (not sure how this happens)
-		case header == nil:
-			continue
-		}
-
-		// Target location where the dir/file should be created
-		target := filepath.Join(core.WritablePath, header.Name)
+	count, err := core.Untar(gr, dest, hook)
+	if err != nil {
+		return err
+	}
+	audit.Printf("Extracted %d entries from %s\n", count, path)
+	return nil
+}
 
-		switch header.Typeflag {
-		// if its a dir and it doesn't exist create it
-		case tar.TypeDir:
-			if _, err := os.Stat(target); err != nil {
-				if err := os.MkdirAll(target, os.FileMode(header.Mode)); err != nil {
-					return err
-				}
-			}
+// removeDeleted removes the paths in the deleted list of a delta archive.
+// The list comes before the changed entries of the delta
+func removeDeleted(header *tar.Header, r io.Reader) (bool, error) {
+	if header.Name != core.DeletedList {
+		return false, nil
+	}
 
-		// if it's a file create it
-		case tar.TypeReg:
-			f, err := os.OpenFile(target, os.O_CREATE|os.O_RDWR, os.FileMode(header.Mode))
-			if err != nil {
-				return err
-			}
-			defer f.Close()
+	deleted, err := core.ReadDeletedList(r)
+	if err != nil {
+		return true, err
+	}
 
-			// copy over contents
-			if _, err := io.Copy(f, tr); err != nil {
-				return err
-			}
+	root := filepath.Clean(core.WritablePath) + string(filepath.Separator)
+	for _, name := range deleted {
+		target := filepath.Join(core.WritablePath, name)
+		if !strings.HasPrefix(target, root) {
+			return true, fmt.Errorf("deleted path `%s` is outside writable", name)
+		}
+		if err := os.RemoveAll(target); err != nil {
+			return true, err
 		}
-
 	}
+	audit.Printf("Removed %d deleted entries\n", len(deleted))
+	return true, nil
 }
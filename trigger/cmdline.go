@@ -0,0 +1,157 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+// Flashback
+// Copyright 2018 Canonical Ltd.  All rights reserved.
+
+package trigger
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/CanonicalLtd/flashback/audit"
+	"github.com/CanonicalLtd/flashback/core"
+)
+
+// CmdlinePath is the path to the kernel command line
+var CmdlinePath = "/proc/cmdline"
+
+// Cmdline is a kernel command line parameter e.g. flashback.reset=1. The
+// value is a token: each token requests one factory reset, and is recorded on
+// the restore partition when it is consumed. A parameter that stays on the
+// command line does not reset the device on every boot
+type Cmdline struct {
+	Param  string
+	Path   string
+	Tokens string
+}
+
+// Name identifies the source in the log
+func (c *Cmdline) Name() string {
+	return fmt.Sprintf("kernel command line %s", c.Param)
+}
+
+// Requested checks whether the parameter is set on the kernel command line
+// with a token that has not been consumed. The restore partition is mounted
+// to read the consumed tokens
+func (c *Cmdline) Requested() (bool, error) {
+	token, ok, err := c.token()
+	if err != nil || !ok {
+		return false, err
+	}
+
+	if err := core.MountReadOnly(core.PartitionTable.Restore, core.RestorePath); err != nil {
+		return false, err
+	}
+	consumed, err := ReadTokens(c.Tokens)
+	_ = core.Unmount(core.RestorePath)
+	if err != nil {
+		return false, err
+	}
+
+	if consumed[token] {
+		audit.Printf("The factory reset request `%s=%s` is already consumed, change the value to request another\n", c.Param, token)
+		return false, nil
+	}
+	return true, nil
+}
+
+// Consume records the token on the restore partition, as the kernel command
+// line cannot be changed
+func (c *Cmdline) Consume() error {
+	token, ok, err := c.token()
+	if err != nil || !ok {
+		return err
+	}
+
+	audit.Printf("Record the factory reset request `%s=%s` as consumed\n", c.Param, token)
+	return core.ChangeRestore(func() error {
+		return AppendToken(c.Tokens, token)
+	})
+}
+
+// token reads the token of the parameter from the kernel command line
+func (c *Cmdline) token() (string, bool, error) {
+	dat, err := ioutil.ReadFile(c.Path)
+	if err != nil {
+		return "", false, err
+	}
+	token, ok := ParamToken(string(dat), c.Param)
+	return token, ok, nil
+}
+
+// ParamToken returns the value of a parameter on the command line, if it
+// requests a factory reset. The last occurrence of the parameter wins, a
+// parameter on its own is the token `1`, and values that switch an option
+// off e.g. 0 or false, are not a request
+func ParamToken(cmdline, param string) (string, bool) {
+	token, ok := "", false
+	for _, field := range strings.Fields(cmdline) {
+		parts := strings.SplitN(field, "=", 2)
+		if parts[0] != param {
+			continue
+		}
+		if len(parts) == 1 {
+			token, ok = "1", true
+			continue
+		}
+		token = strings.Trim(parts[1], `"'`)
+		ok = len(token) > 0 && !isDisabled(token)
+	}
+	return token, ok
+}
+
+// ReadTokens reads the consumed tokens, one per line. A missing file has no tokens
+func ReadTokens(path string) (map[string]bool, error) {
+	tokens := map[string]bool{}
+	dat, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return tokens, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, line := range strings.Split(string(dat), "\n") {
+		if line = strings.TrimSpace(line); len(line) > 0 {
+			tokens[line] = true
+		}
+	}
+	return tokens, nil
+}
+
+// AppendToken adds a consumed token to the end of the file
+func AppendToken(path, token string) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = f.WriteString(token + "\n")
+	if err == nil {
+		err = f.Sync()
+	}
+	if errClose := f.Close(); err == nil {
+		err = errClose
+	}
+	return err
+}
+
+// isDisabled checks whether a value switches an option off
+func isDisabled(value string) bool {
+	switch strings.ToLower(value) {
+	case "0", "false", "no", "off":
+		return true
+	default:
+		return false
+	}
+}
+
+// IsEnabled checks whether a value switches an option on
+func IsEnabled(value string) bool {
+	switch strings.ToLower(strings.Trim(value, `"'`)) {
+	case "1", "true", "yes", "on":
+		return true
+	default:
+		return false
+	}
+}
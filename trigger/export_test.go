@@ -0,0 +1,21 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+// Flashback
+// Copyright 2018 Canonical Ltd.  All rights reserved.
+
+package trigger
+
+import (
+	"time"
+
+	"github.com/CanonicalLtd/flashback/bootenv"
+)
+
+// ConsumeEnv clears the request in an environment block that is already read
+func (g *Grub) ConsumeEnv(env bootenv.Env) {
+	g.consume(env)
+}
+
+// RecordEnv records the outcome in an environment block that is already read
+func (g *Grub) RecordEnv(env bootenv.Env, status string, when time.Time) {
+	g.record(env, status, when)
+}
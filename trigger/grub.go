@@ -0,0 +1,81 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+// Flashback
+// Copyright 2018 Canonical Ltd.  All rights reserved.
+
+package trigger
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/CanonicalLtd/flashback/audit"
+	"github.com/CanonicalLtd/flashback/bootenv"
+	"github.com/CanonicalLtd/flashback/core"
+)
+
+// Grub is a variable in the GRUB environment block on the system-boot partition
+type Grub struct {
+	File      string
+	Request   string
+	Status    string
+	Timestamp string
+	BootEntry string
+}
+
+// Name identifies the source in the log
+func (g *Grub) Name() string {
+	return fmt.Sprintf("GRUB environment variable %s", g.Request)
+}
+
+// Requested checks whether the request variable is set in the environment
+func (g *Grub) Requested() (bool, error) {
+	requested := false
+	err := g.withEnv(false, func(env bootenv.Env) {
+		requested = IsEnabled(env.Get(g.Request))
+	})
+	return requested, err
+}
+
+// Consume removes the request variable from the environment
+func (g *Grub) Consume() error {
+	return g.withEnv(true, g.consume)
+}
+
+func (g *Grub) consume(env bootenv.Env) {
+	env.Unset(g.Request)
+}
+
+// Record saves the outcome and time of the factory reset in the environment.
+// After a successful reset, the one-shot boot entry is selected for the
+// reboot, if one is set. This is done here rather than in Consume, as the
+// reset rewrites system-boot and the environment block on it
+func (g *Grub) Record(status string, when time.Time) error {
+	return g.withEnv(true, func(env bootenv.Env) {
+		g.record(env, status, when)
+	})
+}
+
+func (g *Grub) record(env bootenv.Env, status string, when time.Time) {
+	env.Set(g.Status, status)
+	env.Set(g.Timestamp, when.UTC().Format(time.RFC3339))
+	if status == StatusSuccess && len(g.BootEntry) > 0 {
+		audit.Printf("Boot `%s` on the next boot\n", g.BootEntry)
+		env.Set(bootenv.GrubNextEntry, g.BootEntry)
+	}
+}
+
+// LastReset reads the outcome and time of the last factory reset from the environment
+func (g *Grub) LastReset() (string, string, error) {
+	var status, when string
+	err := g.withEnv(false, func(env bootenv.Env) {
+		status = env.Get(g.Status)
+		when = env.Get(g.Timestamp)
+	})
+	return status, when, err
+}
+
+// withEnv mounts system-boot and reads the environment block. The block is
+// saved after the changes, if requested
+func (g *Grub) withEnv(write bool, change func(env bootenv.Env)) error {
+	return core.WithBootEnv(write, core.GrubEnv(g.File), change)
+}
@@ -0,0 +1,66 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+// Flashback
+// Copyright 2018 Canonical Ltd.  All rights reserved.
+
+package trigger_test
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/CanonicalLtd/flashback/bootenv"
+	"github.com/CanonicalLtd/flashback/trigger"
+	check "gopkg.in/check.v1"
+)
+
+// grubBlock makes a GRUB environment block with the variables
+func grubBlock(vars string) []byte {
+	block := "# GRUB Environment Block\n" + vars
+	return []byte(block + strings.Repeat("#", 1024-len(block)))
+}
+
+func (s *triggerSuite) TestGrubBootEntryAfterRestore(c *check.C) {
+	path := filepath.Join(c.MkDir(), "grubenv")
+	image := grubBlock("saved_entry=0\n")
+	c.Assert(ioutil.WriteFile(path, grubBlock("saved_entry=0\nflashback_reset=1\n"), 0644), check.IsNil)
+
+	g := &trigger.Grub{Request: "flashback_reset", Status: "flashback_status", Timestamp: "flashback_time", BootEntry: "Ubuntu Core"}
+
+	// Consume the request before the reset
+	env, err := bootenv.ReadGrub(path)
+	c.Assert(err, check.IsNil)
+	g.ConsumeEnv(env)
+	c.Assert(env.Write(), check.IsNil)
+
+	// The reset rewrites system-boot from the image
+	c.Assert(ioutil.WriteFile(path, image, 0644), check.IsNil)
+
+	// Record the outcome after the reset
+	env, err = bootenv.ReadGrub(path)
+	c.Assert(err, check.IsNil)
+	g.RecordEnv(env, trigger.StatusSuccess, time.Date(2018, 9, 27, 10, 0, 0, 0, time.UTC))
+	c.Assert(env.Write(), check.IsNil)
+
+	env, err = bootenv.ReadGrub(path)
+	c.Assert(err, check.IsNil)
+	c.Assert(env.Get(bootenv.GrubNextEntry), check.Equals, "Ubuntu Core")
+	c.Assert(env.Get("flashback_status"), check.Equals, trigger.StatusSuccess)
+	c.Assert(env.Get("flashback_time"), check.Equals, "2018-09-27T10:00:00Z")
+	c.Assert(env.Get("flashback_reset"), check.Equals, "")
+}
+
+func (s *triggerSuite) TestGrubBootEntryAfterFailure(c *check.C) {
+	path := filepath.Join(c.MkDir(), "grubenv")
+	c.Assert(ioutil.WriteFile(path, grubBlock("saved_entry=0\n"), 0644), check.IsNil)
+
+	g := &trigger.Grub{Request: "flashback_reset", Status: "flashback_status", Timestamp: "flashback_time", BootEntry: "Ubuntu Core"}
+
+	// The boot entry is not selected when system-boot may not be restored
+	env, err := bootenv.ReadGrub(path)
+	c.Assert(err, check.IsNil)
+	g.RecordEnv(env, trigger.StatusFailed, time.Date(2018, 9, 27, 10, 0, 0, 0, time.UTC))
+	c.Assert(env.Get(bootenv.GrubNextEntry), check.Equals, "")
+	c.Assert(env.Get("flashback_status"), check.Equals, trigger.StatusFailed)
+}
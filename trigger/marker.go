@@ -0,0 +1,73 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+// Flashback
+// Copyright 2018 Canonical Ltd.  All rights reserved.
+
+package trigger
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/CanonicalLtd/flashback/core"
+)
+
+// Marker is a file on the system-boot or restore partition
+type Marker struct {
+	Partition string
+	File      string
+}
+
+// Name identifies the source in the log
+func (m *Marker) Name() string {
+	return fmt.Sprintf("marker file %s on %s", m.File, m.Partition)
+}
+
+// Requested checks whether the marker file exists
+func (m *Marker) Requested() (bool, error) {
+	device, mount, ok := partitionMount(m.Partition)
+	if !ok {
+		return false, fmt.Errorf("marker files are not supported on `%s`", m.Partition)
+	}
+
+	if err := core.MountReadOnly(device, mount); err != nil {
+		return false, err
+	}
+	defer core.Unmount(mount)
+
+	_, err := os.Stat(filepath.Join(mount, m.File))
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// Consume removes the marker file
+func (m *Marker) Consume() error {
+	device, mount, ok := partitionMount(m.Partition)
+	if !ok {
+		return fmt.Errorf("marker files are not supported on `%s`", m.Partition)
+	}
+
+	// The write protection of the restore partition is lifted to remove the marker
+	if m.Partition == core.PartitionRestore {
+		protected, err := core.UnprotectRestore()
+		if err != nil {
+			return err
+		}
+		if protected {
+			defer core.ProtectRestore()
+		}
+	}
+
+	if err := core.Mount(device, mount); err != nil {
+		return err
+	}
+	defer core.Unmount(mount)
+
+	err := os.Remove(filepath.Join(mount, m.File))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,170 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+// Flashback
+// Copyright 2018 Canonical Ltd.  All rights reserved.
+
+package trigger
+
+import (
+	"time"
+
+	"github.com/CanonicalLtd/flashback/audit"
+	"github.com/CanonicalLtd/flashback/config"
+	"github.com/CanonicalLtd/flashback/core"
+)
+
+// Source is a location that can request a factory reset
+type Source interface {
+	// Name identifies the source in the log
+	Name() string
+
+	// Requested checks whether the source is requesting a factory reset
+	Requested() (bool, error)
+
+	// Consume clears the request so the reset is not repeated on the next boot
+	Consume() error
+}
+
+// Recorder is a source that keeps the outcome of the last factory reset
+type Recorder interface {
+	Record(status string, when time.Time) error
+	LastReset() (status string, when string, err error)
+}
+
+// Outcomes of a factory reset that are recorded
+const (
+	StatusSuccess = "success"
+	StatusFailed  = "failed"
+)
+
+// Sources creates the trigger sources from the config
+func Sources() []Source {
+	sources := []Source{}
+
+	if len(config.Store.Triggers.Cmdline) > 0 {
+		sources = append(sources, &Cmdline{Param: config.Store.Triggers.Cmdline, Path: CmdlinePath, Tokens: core.TokensFile})
+	}
+
+	for _, m := range config.Store.Triggers.Markers {
+		sources = append(sources, &Marker{Partition: m.Partition, File: m.File})
+	}
+
+	if len(config.Store.UBoot.File) > 0 {
+		sources = append(sources, &UBoot{
+			File:      config.Store.UBoot.File,
+			Redundant: config.Store.UBoot.Redundant,
+			Request:   config.Store.UBoot.Request,
+			Status:    config.Store.UBoot.Status,
+			Timestamp: config.Store.UBoot.Timestamp,
+		})
+	}
+
+	if len(config.Store.Grub.File) > 0 {
+		sources = append(sources, &Grub{
+			File:      config.Store.Grub.File,
+			Request:   config.Store.Grub.Request,
+			Status:    config.Store.Grub.Status,
+			Timestamp: config.Store.Grub.Timestamp,
+			BootEntry: config.Store.Grub.BootEntry,
+		})
+	}
+
+	return sources
+}
+
+// Check asks each of the sources whether a factory reset is requested and
+// returns the ones that are. A source that cannot be read is logged and skipped
+func Check(sources []Source) []Source {
+	requested := []Source{}
+
+	for _, s := range sources {
+		ok, err := s.Requested()
+		if err != nil {
+			audit.Printf("Error checking `%s` for a factory reset request: %v\n", s.Name(), err)
+			continue
+		}
+		if ok {
+			audit.Printf("Factory reset requested by `%s`\n", s.Name())
+			requested = append(requested, s)
+		}
+	}
+
+	return requested
+}
+
+// Consume clears the requests from the sources and returns the ones that
+// were cleared. A source that cannot be cleared is logged and left out, so
+// its request is kept for the next boot. The error is the last one
+func Consume(sources []Source) ([]Source, error) {
+	cleared := []Source{}
+	var lastErr error
+
+	for _, s := range sources {
+		audit.Printf("Clear the factory reset request from `%s`\n", s.Name())
+		if err := s.Consume(); err != nil {
+			audit.Printf("Error clearing the factory reset request from `%s`: %v\n", s.Name(), err)
+			lastErr = err
+			continue
+		}
+		cleared = append(cleared, s)
+	}
+
+	return cleared, lastErr
+}
+
+// Record saves the outcome of a factory reset in the sources that keep it.
+// Only the sources that requested the reset are given
+func Record(sources []Source, resetErr error) {
+	status := StatusSuccess
+	if resetErr != nil {
+		status = StatusFailed
+	}
+	now := time.Now()
+
+	for _, s := range sources {
+		r, ok := s.(Recorder)
+		if !ok {
+			continue
+		}
+		audit.Printf("Record the factory reset status in `%s`\n", s.Name())
+		if err := r.Record(status, now); err != nil {
+			audit.Printf("Error recording the factory reset status in `%s`: %v\n", s.Name(), err)
+		}
+	}
+}
+
+// LastReset returns the outcome and time of the last factory reset from the
+// first source that has recorded one
+func LastReset(sources []Source) (string, string, bool) {
+	for _, s := range sources {
+		r, ok := s.(Recorder)
+		if !ok {
+			continue
+		}
+		status, when, err := r.LastReset()
+		if err != nil {
+			audit.Printf("Error reading the factory reset status from `%s`: %v\n", s.Name(), err)
+			continue
+		}
+		if len(status) > 0 {
+			return status, when, true
+		}
+	}
+	return "", "", false
+}
+
+// partitionMount returns the device and mount point for a partition that can hold a marker
+func partitionMount(partition string) (string, string, bool) {
+	switch partition {
+	case core.PartitionSystemBoot:
+		return core.PartitionTable.BootPartition(), core.SystemBootPath, true
+	case core.PartitionRestore:
+		return core.PartitionTable.Restore, core.RestorePath, true
+	default:
+		// Partitions of the Ubuntu Core 20 layout
+		r, ok := core.PartitionTable.Role(partition)
+		if !ok || len(r.Device) == 0 {
+			return "", "", false
+		}
+		return r.Device, r.MountPath(), true
+	}
+}
@@ -0,0 +1,100 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+// Flashback
+// Copyright 2018 Canonical Ltd.  All rights reserved.
+
+package trigger_test
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/CanonicalLtd/flashback/trigger"
+	check "gopkg.in/check.v1"
+)
+
+func TestTrigger(t *testing.T) { check.TestingT(t) }
+
+type SuiteTest struct {
+	Cmdline string
+	Token   string
+	Enabled bool
+}
+
+type triggerSuite struct{}
+
+var _ = check.Suite(&triggerSuite{})
+
+func (s *triggerSuite) TestParamToken(c *check.C) {
+	tests := []SuiteTest{
+		{"console=tty1 flashback.reset=1 quiet", "1", true},
+		{"console=tty1 flashback.reset quiet", "1", true},
+		{"flashback.reset=true", "true", true},
+		{"flashback.reset=\"yes\"", "yes", true},
+		{"flashback.reset=20181001-rma", "20181001-rma", true},
+		{"flashback.reset=0", "0", false},
+		{"flashback.reset=off", "off", false},
+		{"flashback.reset=", "", false},
+		{"flashback.reset=1 flashback.reset=0", "0", false},
+		{"flashback.reset=0 flashback.reset=b7", "b7", true},
+		{"flashback.resetting=1", "", false},
+		{"xflashback.reset=1", "", false},
+		{"console=tty1 quiet", "", false},
+		{"", "", false},
+	}
+
+	for _, t := range tests {
+		token, ok := trigger.ParamToken(t.Cmdline, "flashback.reset")
+		c.Assert(ok, check.Equals, t.Enabled, check.Commentf(t.Cmdline))
+		c.Assert(token, check.Equals, t.Token, check.Commentf(t.Cmdline))
+	}
+}
+
+func (s *triggerSuite) TestTokens(c *check.C) {
+	path := filepath.Join(c.MkDir(), "cmdline-tokens")
+
+	tokens, err := trigger.ReadTokens(path)
+	c.Assert(err, check.IsNil)
+	c.Assert(tokens, check.HasLen, 0)
+
+	c.Assert(trigger.AppendToken(path, "1"), check.IsNil)
+	c.Assert(trigger.AppendToken(path, "20181001-rma"), check.IsNil)
+
+	tokens, err = trigger.ReadTokens(path)
+	c.Assert(err, check.IsNil)
+	c.Assert(tokens, check.DeepEquals, map[string]bool{"1": true, "20181001-rma": true})
+}
+
+// fakeSource is a source whose request can or cannot be cleared
+type fakeSource struct {
+	name     string
+	consumed bool
+	err      error
+}
+
+func (f *fakeSource) Name() string             { return f.name }
+func (f *fakeSource) Requested() (bool, error) { return !f.consumed, nil }
+func (f *fakeSource) Consume() error {
+	if f.err != nil {
+		return f.err
+	}
+	f.consumed = true
+	return nil
+}
+
+func (s *triggerSuite) TestConsume(c *check.C) {
+	marker := &fakeSource{name: "marker"}
+	cmdline := &fakeSource{name: "cmdline", err: errors.New("read-only file system")}
+
+	cleared, err := trigger.Consume([]trigger.Source{marker, cmdline})
+	c.Assert(err, check.ErrorMatches, "read-only file system")
+	c.Assert(cleared, check.DeepEquals, []trigger.Source{marker})
+	c.Assert(marker.consumed, check.Equals, true)
+
+	// The request that was not cleared is still there
+	c.Assert(trigger.Check([]trigger.Source{marker, cmdline}), check.DeepEquals, []trigger.Source{cmdline})
+
+	cleared, err = trigger.Consume([]trigger.Source{cmdline})
+	c.Assert(err, check.NotNil)
+	c.Assert(cleared, check.HasLen, 0)
+}
@@ -0,0 +1,67 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+// Flashback
+// Copyright 2018 Canonical Ltd.  All rights reserved.
+
+package trigger
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/CanonicalLtd/flashback/bootenv"
+	"github.com/CanonicalLtd/flashback/core"
+)
+
+// UBoot is a variable in the U-Boot environment on the system-boot partition
+type UBoot struct {
+	File      string
+	Redundant string
+	Request   string
+	Status    string
+	Timestamp string
+}
+
+// Name identifies the source in the log
+func (u *UBoot) Name() string {
+	return fmt.Sprintf("U-Boot environment variable %s", u.Request)
+}
+
+// Requested checks whether the request variable is set in the environment
+func (u *UBoot) Requested() (bool, error) {
+	requested := false
+	err := u.withEnv(false, func(env bootenv.Env) {
+		requested = IsEnabled(env.Get(u.Request))
+	})
+	return requested, err
+}
+
+// Consume removes the request variable from the environment
+func (u *UBoot) Consume() error {
+	return u.withEnv(true, func(env bootenv.Env) {
+		env.Unset(u.Request)
+	})
+}
+
+// Record saves the outcome and time of the factory reset in the environment
+func (u *UBoot) Record(status string, when time.Time) error {
+	return u.withEnv(true, func(env bootenv.Env) {
+		env.Set(u.Status, status)
+		env.Set(u.Timestamp, when.UTC().Format(time.RFC3339))
+	})
+}
+
+// LastReset reads the outcome and time of the last factory reset from the environment
+func (u *UBoot) LastReset() (string, string, error) {
+	var status, when string
+	err := u.withEnv(false, func(env bootenv.Env) {
+		status = env.Get(u.Status)
+		when = env.Get(u.Timestamp)
+	})
+	return status, when, err
+}
+
+// withEnv mounts system-boot and reads the environment. The environment is
+// saved after the changes, if requested
+func (u *UBoot) withEnv(write bool, change func(env bootenv.Env)) error {
+	return core.WithBootEnv(write, core.UBootEnv(u.File, u.Redundant), change)
+}